@@ -28,6 +28,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"humungus.tedunangst.com/r/webs/cache"
@@ -67,18 +68,36 @@ var develClient = &http.Client{
 	},
 }
 
+type PostError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *PostError) Error() string {
+	return fmt.Sprintf("http post status: %d", e.StatusCode)
+}
+
 func PostJunk(keyname string, key httpsig.PrivateKey, url string, j junk.Junk) error {
-	return PostMsg(keyname, key, url, j.ToBytes())
+	_, err := PostMsg(keyname, key, url, j.ToBytes())
+	return err
 }
 
-func PostMsg(keyname string, key httpsig.PrivateKey, url string, msg []byte) error {
+// returns the response status, if there was one
+func PostMsg(keyname string, key httpsig.PrivateKey, url string, msg []byte) (int, error) {
 	client := http.DefaultClient
 	if develMode {
 		client = develClient
 	}
+	done, err := hostthrottle(url)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	garage.Start()
+	defer garage.Finish()
 	req, err := http.NewRequest("POST", url, bytes.NewReader(msg))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Set("User-Agent", "honksnonk/5.0; "+serverName)
 	req.Header.Set("Content-Type", theonetruename)
@@ -88,18 +107,19 @@ func PostMsg(keyname string, key httpsig.PrivateKey, url string, msg []byte) err
 	req = req.WithContext(ctx)
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	resp.Body.Close()
+	pleasewait(url, resp)
 	switch resp.StatusCode {
 	case 200:
 	case 201:
 	case 202:
 	default:
-		return fmt.Errorf("http post status: %d", resp.StatusCode)
+		return resp.StatusCode, &PostError{URL: url, StatusCode: resp.StatusCode}
 	}
 	ilog.Printf("successful post: %s %d", url, resp.StatusCode)
-	return nil
+	return resp.StatusCode, nil
 }
 
 func GetJunk(userid int64, url string) (junk.Junk, error) {
@@ -159,16 +179,26 @@ func junkGet(userid int64, url string, args junk.GetArgs) (junk.Junk, error) {
 		defer cancel()
 		req = req.WithContext(ctx)
 	}
+	done, err := hostthrottle(url)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	pleasewait(url, resp)
 
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("http get status: %d", resp.StatusCode)
 	}
-	return junk.Read(resp.Body)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	return parsejunk(data)
 }
 
 func GetJunkTimeout(userid int64, url string, timeout time.Duration) (junk.Junk, error) {
@@ -212,6 +242,12 @@ func fetchsome(url string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 	req = req.WithContext(ctx)
+	done, err := hostthrottle(url)
+	if err != nil {
+		ilog.Printf("error fetching %s: %s", url, err)
+		return nil, err
+	}
+	defer done()
 	resp, err := client.Do(req)
 	if err != nil {
 		ilog.Printf("error fetching %s: %s", url, err)
@@ -231,7 +267,7 @@ func fetchsome(url string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func savedonk(url string, name, desc, media string, localize bool) *Donk {
+func savedonk(url string, name, desc, media string, localize bool, meta DonkMeta) *Donk {
 	if url == "" {
 		return nil
 	}
@@ -256,7 +292,7 @@ func savedonk(url string, name, desc, media string, localize bool) *Donk {
 			ilog.Printf("truncation likely")
 		}
 		if strings.HasPrefix(media, "image") {
-			img, err := shrinkit(data)
+			img, m, err := shrinkit(data)
 			if err != nil {
 				ilog.Printf("unable to decode image: %s", err)
 				localize = false
@@ -265,6 +301,7 @@ func savedonk(url string, name, desc, media string, localize bool) *Donk {
 			}
 			data = img.Data
 			media = "image/" + img.Format
+			meta = m
 		} else if media == "application/pdf" {
 			if len(data) > 1000000 {
 				ilog.Printf("not saving large pdf")
@@ -278,7 +315,7 @@ func savedonk(url string, name, desc, media string, localize bool) *Donk {
 		}
 	}
 saveit:
-	fileid, err := savefile(name, desc, url, media, localize, data)
+	fileid, err := savefile(name, desc, url, media, localize, data, meta)
 	if err != nil {
 		elog.Printf("error saving file %s: %s", url, err)
 		return nil
@@ -345,7 +382,7 @@ func eradicatexonk(userid int64, xid string) {
 	if xonk != nil {
 		deletehonk(xonk.ID)
 	}
-	_, err := stmtSaveZonker.Exec(userid, xid, "zonk")
+	err := savezonker(userid, xid, "zonk", time.Time{})
 	if err != nil {
 		elog.Printf("error eradicating: %s", err)
 	}
@@ -723,6 +760,9 @@ func xonksaver(user *WhatAbout, item junk.Junk, origin string) *Honk {
 				dt = dt2
 			}
 			content, _ := obj.GetString("content")
+			if cmap, ok := obj.GetMap("contentMap"); ok {
+				xonk.Lang = contentlang(cmap)
+			}
 			if !strings.HasPrefix(content, "<p>") {
 				content = "<p>" + content
 			}
@@ -841,7 +881,18 @@ func xonksaver(user *WhatAbout, item junk.Junk, origin string) *Honk {
 				if skipMedia(&xonk) {
 					localize = false
 				}
-				donk := savedonk(u, name, desc, mt, localize)
+				var meta DonkMeta
+				wide, _ := att.GetNumber("width")
+				high, _ := att.GetNumber("height")
+				if wide > 0 && high > 0 && wide < 100000 && high < 100000 {
+					meta.Width = int(wide)
+					meta.Height = int(high)
+				}
+				meta.Blurhash, _ = att.GetString("blurhash")
+				if len(meta.Blurhash) > 100 {
+					meta.Blurhash = ""
+				}
+				donk := savedonk(u, name, desc, mt, localize, meta)
 				if donk != nil {
 					xonk.Donks = append(xonk.Donks, donk)
 				}
@@ -879,7 +930,7 @@ func xonksaver(user *WhatAbout, item junk.Junk, origin string) *Honk {
 						mt = "image/png"
 					}
 					u, _ := icon.GetString("url")
-					donk := savedonk(u, name, desc, mt, true)
+					donk := savedonk(u, name, desc, mt, true, DonkMeta{})
 					if donk != nil {
 						xonk.Donks = append(xonk.Donks, donk)
 					}
@@ -1023,6 +1074,11 @@ func xonksaver(user *WhatAbout, item junk.Junk, origin string) *Honk {
 			}
 			xonk.Convoy = convoy
 			savexonk(&xonk)
+			if xonk.Whofore == 1 {
+				notify(user, "mention", xonk.Honker, xonk.XID, "")
+			} else if what == "tonk" && tookpart(user, convoy) {
+				notify(user, "reply", xonk.Honker, xonk.XID, "")
+			}
 		}
 		if goingup == 0 {
 			for _, replid := range replies {
@@ -1038,6 +1094,170 @@ func xonksaver(user *WhatAbout, item junk.Junk, origin string) *Honk {
 	return xonkxonkfn(item, origin, false)
 }
 
+// items from a collection, page by page, up to convoyFetchCount
+func collectionitems(userid int64, c interface{}) []string {
+	getpage := func(c interface{}) junk.Junk {
+		switch v := c.(type) {
+		case string:
+			j, err := GetJunk(userid, v)
+			if err != nil {
+				ilog.Printf("error getting collection: %s", err)
+				return nil
+			}
+			return j
+		case junk.Junk:
+			return v
+		}
+		return nil
+	}
+	coll := getpage(c)
+	if coll == nil {
+		return nil
+	}
+	_, hasitems := coll.GetArray("items")
+	_, hasordered := coll.GetArray("orderedItems")
+	if first, ok := coll["first"]; ok && !hasitems && !hasordered {
+		coll = getpage(first)
+	}
+	var xids []string
+	seen := make(map[string]bool)
+	for pages := 0; coll != nil && pages < 50 && len(xids) < convoyFetchCount; pages++ {
+		items, ok := coll.GetArray("orderedItems")
+		if !ok {
+			items, _ = coll.GetArray("items")
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case string:
+				xids = append(xids, v)
+			case junk.Junk:
+				if xid, ok := v.GetString("id"); ok {
+					xids = append(xids, xid)
+				}
+			}
+		}
+		// pages may be links or embedded, and may loop
+		next, ok := coll["next"]
+		if !ok {
+			break
+		}
+		if id, ok := coll.GetString("id"); ok {
+			seen[id] = true
+		}
+		nextid, _ := next.(string)
+		if nj, ok := next.(junk.Junk); ok {
+			nextid, _ = nj.GetString("id")
+		}
+		if nextid != "" && seen[nextid] {
+			break
+		}
+		coll = getpage(next)
+	}
+	return xids
+}
+
+type ConvoyFetch struct {
+	Fetched int
+	Pending int
+}
+
+var convoyfetches = make(map[string]*ConvoyFetch)
+var convoyfetcheslock sync.Mutex
+
+var convoyFetchDepth = 10
+var convoyFetchCount = 200
+
+func convoyfetchkey(userid int64, convoy string) string {
+	return fmt.Sprintf("%d %s", userid, convoy)
+}
+
+func convoyprogress(userid int64, convoy string) *ConvoyFetch {
+	convoyfetcheslock.Lock()
+	defer convoyfetcheslock.Unlock()
+	cf := convoyfetches[convoyfetchkey(userid, convoy)]
+	if cf == nil {
+		return nil
+	}
+	progress := *cf
+	return &progress
+}
+
+// go looking for the rest of the conversation
+func fetchconvoy(user *WhatAbout, convoy string) {
+	key := convoyfetchkey(user.ID, convoy)
+	convoyfetcheslock.Lock()
+	if convoyfetches[key] != nil {
+		convoyfetcheslock.Unlock()
+		return
+	}
+	cf := new(ConvoyFetch)
+	convoyfetches[key] = cf
+	convoyfetcheslock.Unlock()
+	defer func() {
+		convoyfetcheslock.Lock()
+		delete(convoyfetches, key)
+		convoyfetcheslock.Unlock()
+	}()
+
+	type fetchitem struct {
+		xid   string
+		depth int
+	}
+	var todo []fetchitem
+	seen := make(map[string]bool)
+	addone := func(xid string, depth int) {
+		if seen[xid] || depth > convoyFetchDepth {
+			return
+		}
+		if !strings.HasPrefix(xid, "https://") || strings.HasPrefix(xid, user.URL+"/") {
+			return
+		}
+		seen[xid] = true
+		todo = append(todo, fetchitem{xid, depth})
+	}
+	for _, h := range gethonksbyconvoy(user.ID, convoy, 0) {
+		addone(h.XID, 0)
+		addone(h.RID, 1)
+	}
+	if strings.HasPrefix(convoy, "https://") {
+		for _, xid := range collectionitems(user.ID, convoy) {
+			addone(xid, 1)
+		}
+	}
+
+	ilog.Printf("fetching convoy %s", convoy)
+	fetched := 0
+	for len(todo) > 0 && fetched < convoyFetchCount {
+		item := todo[0]
+		todo = todo[1:]
+		convoyfetcheslock.Lock()
+		cf.Fetched = fetched
+		cf.Pending = len(todo) + 1
+		convoyfetcheslock.Unlock()
+
+		obj, err := GetJunkHardMode(user.ID, item.xid)
+		fetched++
+		if err != nil {
+			ilog.Printf("error getting convoy item: %s: %s", item.xid, err)
+			continue
+		}
+		if needxonkid(user, item.xid) {
+			xonksaver(user, obj, originate(item.xid))
+		}
+		rid, ok := obj.GetString("inReplyTo")
+		if !ok {
+			if robj, ok := obj.GetMap("inReplyTo"); ok {
+				rid, _ = robj.GetString("id")
+			}
+		}
+		addone(rid, item.depth+1)
+		for _, xid := range collectionitems(user.ID, obj["replies"]) {
+			addone(xid, item.depth+1)
+		}
+	}
+	ilog.Printf("fetched %d for convoy %s", fetched, convoy)
+}
+
 func dumpactivity(item junk.Junk) {
 	fd, err := os.OpenFile("savedinbox.json", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
@@ -1110,7 +1330,19 @@ func activatedonks(donks []*Donk) []junk.Junk {
 		jd["name"] = d.Name
 		jd["summary"] = html.EscapeString(d.Desc)
 		jd["type"] = "Document"
+		if strings.HasPrefix(d.Media, "video/") {
+			jd["type"] = "Video"
+		} else if strings.HasPrefix(d.Media, "audio/") {
+			jd["type"] = "Audio"
+		}
 		jd["url"] = d.URL
+		if d.Meta.Width > 0 && d.Meta.Height > 0 {
+			jd["width"] = d.Meta.Width
+			jd["height"] = d.Meta.Height
+		}
+		if d.Meta.Blurhash != "" {
+			jd["blurhash"] = d.Meta.Blurhash
+		}
 		atts = append(atts, jd)
 	}
 	return atts
@@ -1256,6 +1488,9 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 		if w := h.Wonkles; w != "" {
 			jo["wordlist"] = w
 		}
+		if !h.Expires.IsZero() {
+			jo["expires"] = h.Expires.UTC().Format(time.RFC3339)
+		}
 		atts := activatedonks(h.Donks)
 		if len(atts) > 0 {
 			jo["attachment"] = atts
@@ -1315,7 +1550,7 @@ func jonkjonk(user *WhatAbout, h *Honk) (junk.Junk, junk.Junk) {
 var oldjonks = cache.New(cache.Options{Filler: func(xid string) ([]byte, bool) {
 	row := stmtAnyXonk.QueryRow(xid)
 	honk := scanhonk(row)
-	if honk == nil || !honk.Public {
+	if honk == nil || !honk.Public || honk.Whofore == 4 {
 		return nil, true
 	}
 	user, _ := butwhatabout(honk.Username)
@@ -1675,6 +1910,167 @@ func somethingabout(obj junk.Junk) (*SomeThing, error) {
 	return info, nil
 }
 
+var profileExpiry = 24 * time.Hour
+
+var profiles = cache.New(cache.Options{Filler: func(xid string) (*Profile, bool) {
+	if p := cachedprofile(xid); p != nil {
+		return p, true
+	}
+	dlog.Printf("fetching profile: %s", xid)
+	obj, err := GetJunkFast(serverUID, xid)
+	if err != nil {
+		ilog.Printf("error getting profile %s: %s", xid, err)
+		return nil, true
+	}
+	if info, err := somethingabout(obj); err != nil || info.What != SomeActor {
+		return nil, true
+	}
+	p := profilefrom(obj)
+	if p.XID != xid {
+		ilog.Printf("profile id mismatch: %s <> %s", p.XID, xid)
+		return nil, true
+	}
+	j, err := jsonify(p)
+	if err == nil {
+		when := time.Now().UTC().Format(dbtimeformat)
+		_, err = stmtSaveXonker.Exec(xid, j, "profile", when)
+	}
+	if err != nil {
+		elog.Printf("error saving profile: %s", err)
+	}
+	return p, true
+}, Duration: 1 * time.Minute})
+
+// go looking for a missing profile, but not every time somebody asks
+var profilefetches = cache.New(cache.Options{Filler: func(xid string) (bool, bool) {
+	go getprofile(xid)
+	return true, true
+}, Duration: 1 * time.Hour})
+
+func refetchprofile(xid string) {
+	var ok bool
+	profilefetches.Get(xid, &ok)
+}
+
+// whatever we have saved, without going to look for more
+func cachedprofile(xid string) *Profile {
+	when := time.Now().Add(-profileExpiry).UTC().Format(dbtimeformat)
+	stmtDeleteXonker.Exec(xid, "profile", when)
+	j := getxonker(xid, "profile")
+	if j == "" {
+		return nil
+	}
+	p := new(Profile)
+	err := unjsonify(j, p)
+	if err != nil {
+		elog.Printf("error parsing profile: %s", err)
+		return nil
+	}
+	return p
+}
+
+func getprofile(xid string) *Profile {
+	var p *Profile
+	profiles.Get(xid, &p)
+	return p
+}
+
+func profilefrom(obj junk.Junk) *Profile {
+	p := new(Profile)
+	p.XID, _ = obj.GetString("id")
+	p.Name, _ = obj.GetString("name")
+	p.Handle, _ = obj.GetString("preferredUsername")
+	p.Summary, _ = obj.GetString("summary")
+	if icon, ok := obj.GetMap("icon"); ok {
+		p.Icon, _ = icon.GetString("url")
+	}
+	if image, ok := obj.GetMap("image"); ok {
+		p.Image, _ = image.GetString("url")
+	}
+	atts, _ := obj.GetArray("attachment")
+	for _, a := range atts {
+		att, ok := a.(junk.Junk)
+		if !ok {
+			continue
+		}
+		if t, _ := att.GetString("type"); t != "PropertyValue" {
+			continue
+		}
+		var f ProfileField
+		f.Name, _ = att.GetString("name")
+		f.Value, _ = att.GetString("value")
+		p.Fields = append(p.Fields, f)
+	}
+	if dt, ok := obj.GetString("published"); ok {
+		p.Created, _ = time.Parse(time.RFC3339, dt)
+	}
+	origin := originate(p.XID)
+	howmany := func(c string) int64 {
+		if c == "" || originate(c) != origin {
+			return 0
+		}
+		j, err := GetJunkFast(serverUID, c)
+		if err != nil {
+			ilog.Printf("error getting collection: %s", err)
+			return 0
+		}
+		n, _ := j.GetNumber("totalItems")
+		return int64(n)
+	}
+	followers, _ := obj.GetString("followers")
+	p.Followers = howmany(followers)
+	following, _ := obj.GetString("following")
+	p.Following = howmany(following)
+	if p.Icon != "" {
+		p.Avatar = saveprofileimage(p.Icon)
+	}
+	if p.Image != "" {
+		p.Header = saveprofileimage(p.Image)
+	}
+	return p
+}
+
+// keep a local copy so we needn't hotlink
+func saveprofileimage(url string) string {
+	if donk := finddonk(url); donk != nil {
+		return donk.XID
+	}
+	fn := func() (interface{}, error) {
+		return fetchsome(url)
+	}
+	ii, err := flightdeck.Call(url, fn)
+	if err != nil {
+		ilog.Printf("error fetching profile image: %s", err)
+		return ""
+	}
+	img, meta, err := shrinkit(ii.([]byte))
+	if err != nil {
+		ilog.Printf("unable to decode profile image: %s", err)
+		return ""
+	}
+	_, xid, err := savefileandxid("", "", url, "image/"+img.Format, true, img.Data, meta)
+	if err != nil {
+		elog.Printf("error saving profile image: %s", err)
+		return ""
+	}
+	return xid
+}
+
+// the first language, the same one every time
+func contentlang(cmap junk.Junk) string {
+	var best string
+	for lang := range cmap {
+		lang = strings.ToLower(lang)
+		if lang == "" || len(lang) >= 16 {
+			continue
+		}
+		if best == "" || lang < best {
+			best = lang
+		}
+	}
+	return best
+}
+
 func allinjest(origin string, obj junk.Junk) {
 	keyobj, ok := obj.GetMap("publicKey")
 	if ok {
@@ -1829,6 +2225,7 @@ func followme(user *WhatAbout, who string, name string, j junk.Junk) {
 		}
 	} else {
 		stmtSaveDub.Exec(user.ID, name, who, "dub", folxid)
+		notify(user, "follow", who, "", "")
 	}
 	go rubadubdub(user, j)
 }
@@ -17,25 +17,44 @@ package main
 
 import (
 	"bytes"
+	"encoding/gob"
+	"fmt"
+	goimage "image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"net"
 	"net/rpc"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"humungus.tedunangst.com/r/webs/cache"
 	"humungus.tedunangst.com/r/webs/gate"
+	"humungus.tedunangst.com/r/webs/htfilter"
 	"humungus.tedunangst.com/r/webs/image"
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/templates"
 )
 
 type Shrinker struct {
 }
 
 type ShrinkerArgs struct {
-	Buf    []byte
-	Params image.Params
+	Buf      []byte
+	Params   image.Params
+	Variants []int
 }
 
 type ShrinkerResult struct {
-	Image *image.Image
+	Image    *image.Image
+	Variants []*image.Image
+	Meta     DonkMeta
 }
 
 var shrinkgate = gate.NewLimiter(4)
@@ -48,6 +67,26 @@ func (s *Shrinker) Shrink(args *ShrinkerArgs, res *ShrinkerResult) error {
 		return err
 	}
 	res.Image = img
+	if pic, _, err := goimage.Decode(bytes.NewReader(img.Data)); err == nil {
+		bounds := pic.Bounds()
+		res.Meta.Width = bounds.Dx()
+		res.Meta.Height = bounds.Dy()
+		res.Meta.Blurhash = blurhash(pic, 4, 3)
+	}
+	// only sizes smaller than what we already have are worth making
+	for _, size := range args.Variants {
+		if size >= res.Meta.Width {
+			continue
+		}
+		params := args.Params
+		params.MaxWidth = size
+		v, err := image.Vacuum(bytes.NewReader(img.Data), params)
+		if err != nil {
+			return err
+		}
+		res.Variants = append(res.Variants, v)
+		res.Meta.Variants = append(res.Meta.Variants, size)
+	}
 	return nil
 }
 
@@ -55,21 +94,366 @@ func backendSockname() string {
 	return dataDir + "/backend.sock"
 }
 
-func shrinkit(data []byte) (*image.Image, error) {
-	cl, err := rpc.Dial("unix", backendSockname())
-	if err != nil {
-		return nil, err
+var backendlock sync.Mutex
+var backendclient *rpc.Client
+
+// one connection, redialed whenever the backend goes away
+func callbackend(method string, args interface{}, res interface{}) error {
+	for tries := 0; ; tries++ {
+		backendlock.Lock()
+		cl := backendclient
+		if cl == nil {
+			var err error
+			cl, err = rpc.Dial("unix", backendSockname())
+			if err != nil {
+				backendlock.Unlock()
+				return err
+			}
+			backendclient = cl
+		}
+		backendlock.Unlock()
+		err := cl.Call(method, args, res)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(rpc.ServerError); ok || tries > 0 {
+			return err
+		}
+		backendlock.Lock()
+		if backendclient == cl {
+			backendclient = nil
+		}
+		backendlock.Unlock()
+		cl.Close()
 	}
-	defer cl.Close()
+}
+
+// smaller copies for timelines and phones
+var imageVariants = []int{400, 1024}
+
+func shrinkit(data []byte) (*image.Image, DonkMeta, error) {
 	var res ShrinkerResult
-	err = cl.Call("Shrinker.Shrink", &ShrinkerArgs{
-		Buf:    data,
-		Params: image.Params{LimitSize: 4200 * 4200, MaxWidth: 2048, MaxHeight: 2048},
+	err := callbackend("Shrinker.Shrink", &ShrinkerArgs{
+		Buf:      data,
+		Params:   image.Params{LimitSize: 4200 * 4200, MaxWidth: 2048, MaxHeight: 2048},
+		Variants: imageVariants,
 	}, &res)
 	if err != nil {
-		return nil, err
+		return nil, res.Meta, err
+	}
+	res.Meta.variants = res.Variants
+	return res.Image, res.Meta, nil
+}
+
+type Sanitizer struct {
+}
+
+type SanitizeDonk struct {
+	XID   string
+	Name  string
+	URL   string
+	Local bool
+}
+
+type SanitizeItem struct {
+	BaseURL string
+	Precis  string
+	Noise   string
+	Donks   []SanitizeDonk
+}
+
+type SanitizeArgs struct {
+	Items []SanitizeItem
+}
+
+type SanitizedItem struct {
+	Precis string
+	Noise  string
+	Zapped []string
+}
+
+type SanitizeResult struct {
+	Items []SanitizedItem
+}
+
+func (s *Sanitizer) Sanitize(args *SanitizeArgs, res *SanitizeResult) error {
+	for _, item := range args.Items {
+		zap := make(map[string]bool)
+		imager := func(node *html.Node) string {
+			src := htfilter.GetAttr(node, "src")
+			alt := htfilter.GetAttr(node, "alt")
+			if htfilter.HasClass(node, "Emoji") && alt != "" {
+				return alt
+			}
+			for _, d := range item.Donks {
+				if d.Local && d.URL == src {
+					zap[d.XID] = true
+					return string(templates.Sprintf(`<img alt="%s" title="%s" src="/d/%s">`, alt, alt, d.XID))
+				}
+			}
+			return string(templates.Sprintf(`&lt;img alt="%s" src="<a href="%s">%s</a>"&gt;`, alt, src, src))
+		}
+		emuxifier := func(e string) string {
+			for _, d := range item.Donks {
+				if d.Name == e {
+					zap[d.XID] = true
+					if d.Local {
+						return fmt.Sprintf(`<img class="emu" title="%s" src="/d/%s">`, d.Name, d.XID)
+					}
+				}
+			}
+			return e
+		}
+		var out SanitizedItem
+		out.Precis, out.Noise = filterhonkhtml(item.BaseURL, item.Precis, item.Noise, imager, emuxifier)
+		for xid := range zap {
+			out.Zapped = append(out.Zapped, xid)
+		}
+		res.Items = append(res.Items, out)
+	}
+	return nil
+}
+
+type ImagesArgs struct {
+	HTML    string
+	BaseURL string
+}
+
+type FoundImage struct {
+	Src string
+	Alt string
+}
+
+func (s *Sanitizer) Images(args *ImagesArgs, res *[]FoundImage) error {
+	var htf htfilter.Filter
+	htf.Imager = func(node *html.Node) string {
+		*res = append(*res, FoundImage{
+			Src: htfilter.GetAttr(node, "src"),
+			Alt: htfilter.GetAttr(node, "alt"),
+		})
+		return ""
+	}
+	htf.BaseURL, _ = url.Parse(args.BaseURL)
+	htf.String(args.HTML)
+	return nil
+}
+
+func sanitizehonks(honks []*Honk) {
+	if len(honks) == 0 {
+		return
+	}
+	var args SanitizeArgs
+	for _, h := range honks {
+		item := SanitizeItem{BaseURL: h.XID, Precis: h.Precis, Noise: h.Noise}
+		for _, d := range h.Donks {
+			item.Donks = append(item.Donks, SanitizeDonk{XID: d.XID, Name: d.Name, URL: d.URL, Local: d.Local})
+		}
+		// inline images may match any file we have, not just this honk's
+		if strings.Contains(h.Noise, "<img") {
+			for _, img := range findimages(h) {
+				if d := finddonk(img.Src); d != nil {
+					item.Donks = append(item.Donks, SanitizeDonk{XID: d.XID, URL: img.Src, Local: true})
+				}
+			}
+		}
+		args.Items = append(args.Items, item)
+	}
+	var res SanitizeResult
+	err := callbackend("Sanitizer.Sanitize", &args, &res)
+	if err != nil || len(res.Items) != len(honks) {
+		elog.Printf("error sanitizing honks: %s", err)
+		for _, h := range honks {
+			h.Precis = html.EscapeString(h.Precis)
+			h.Noise = html.EscapeString(h.Noise)
+		}
+		return
+	}
+	for i, h := range honks {
+		out := res.Items[i]
+		h.Precis = out.Precis
+		h.Noise = out.Noise
+		zap := make(map[string]bool)
+		for _, xid := range out.Zapped {
+			zap[xid] = true
+		}
+		zapdonks(h, zap)
+	}
+}
+
+type FilterArgs struct {
+	BaseURL string
+	HTML    []string
+}
+
+func (s *Sanitizer) Filter(args *FilterArgs, res *[]string) error {
+	var htf htfilter.Filter
+	htf.SpanClasses = allowedclasses
+	htf.BaseURL, _ = url.Parse(args.BaseURL)
+	for _, h := range args.HTML {
+		out, _ := htf.String(h)
+		*res = append(*res, string(out))
+	}
+	return nil
+}
+
+func (s *Sanitizer) Junk(data *[]byte, res *junk.Junk) error {
+	j, err := junk.FromBytes(*data)
+	if err != nil {
+		return err
+	}
+	*res = j
+	return nil
+}
+
+func init() {
+	// what junk is made of, so it can come back from the backend
+	gob.Register(junk.Junk{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// somebody else's html, cleaned up by the backend
+func filterhtml(baseurl string, htmls ...string) []string {
+	var res []string
+	err := callbackend("Sanitizer.Filter", &FilterArgs{BaseURL: baseurl, HTML: htmls}, &res)
+	if err != nil || len(res) != len(htmls) {
+		elog.Printf("error filtering html: %s", err)
+		res = make([]string, len(htmls))
+		for i, h := range htmls {
+			res[i] = html.EscapeString(h)
+		}
+	}
+	return res
+}
+
+// somebody else's json, parsed by the backend
+func parsejunk(data []byte) (junk.Junk, error) {
+	var j junk.Junk
+	err := callbackend("Sanitizer.Junk", &data, &j)
+	return j, err
+}
+
+func findimages(h *Honk) []FoundImage {
+	var res []FoundImage
+	err := callbackend("Sanitizer.Images", &ImagesArgs{HTML: h.Noise, BaseURL: h.XID}, &res)
+	if err != nil {
+		elog.Printf("error finding images: %s", err)
 	}
-	return res.Image, nil
+	return res
+}
+
+type Hooter struct {
+}
+
+type HootArgs struct {
+	HTML []byte
+	URL  string
+	Seen map[string]bool
+}
+
+type HootResult struct {
+	Text string
+	Seen map[string]bool
+}
+
+func (hr *Hooter) Extract(args *HootArgs, res *HootResult) error {
+	seen := args.Seen
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	res.Text = hootextractor(bytes.NewReader(args.HTML), args.URL, seen)
+	res.Seen = seen
+	return nil
+}
+
+type Previewer struct {
+}
+
+type PreviewArgs struct {
+	HTML []byte
+	URL  string
+}
+
+type LinkPreview struct {
+	URL         string
+	Title       string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Image       string `json:",omitempty"`
+	SiteName    string `json:",omitempty"`
+}
+
+var metasel = cascadia.MustCompile("head meta")
+var titlesel = cascadia.MustCompile("head title")
+
+func (p *Previewer) Preview(args *PreviewArgs, res *LinkPreview) error {
+	root, err := html.Parse(bytes.NewReader(args.HTML))
+	if err != nil {
+		return err
+	}
+	res.URL = args.URL
+	var htf htfilter.Filter
+	if node := titlesel.MatchFirst(root); node != nil {
+		res.Title = strings.TrimSpace(htf.NodeText(node))
+	}
+	for _, node := range metasel.MatchAll(root) {
+		key := htfilter.GetAttr(node, "property")
+		if key == "" {
+			key = htfilter.GetAttr(node, "name")
+		}
+		val := strings.TrimSpace(htfilter.GetAttr(node, "content"))
+		if val == "" {
+			continue
+		}
+		switch key {
+		case "og:title":
+			res.Title = val
+		case "og:description":
+			res.Description = val
+		case "description":
+			if res.Description == "" {
+				res.Description = val
+			}
+		case "og:image":
+			res.Image = val
+		case "og:site_name":
+			res.SiteName = val
+		}
+	}
+	if base, err := url.Parse(args.URL); err == nil && res.Image != "" {
+		if u, err := base.Parse(res.Image); err == nil {
+			res.Image = u.String()
+		}
+	}
+	if len(res.Title) > 300 {
+		res.Title = res.Title[:300]
+	}
+	if len(res.Description) > 1000 {
+		res.Description = res.Description[:1000]
+	}
+	return nil
+}
+
+var previews = cache.New(cache.Options{Filler: func(link string) (*LinkPreview, bool) {
+	data, err := fetchsome(link)
+	if err != nil {
+		return nil, false
+	}
+	res := new(LinkPreview)
+	err = callbackend("Previewer.Preview", &PreviewArgs{HTML: data, URL: link}, res)
+	if err != nil {
+		ilog.Printf("error previewing %s: %s", link, err)
+		return nil, false
+	}
+	return res, true
+}, Duration: 1 * time.Hour})
+
+func linkpreview(link string) *LinkPreview {
+	var p *LinkPreview
+	ok := previews.Get(link, &p)
+	if !ok {
+		return nil
+	}
+	return p
 }
 
 var backendhooks []func()
@@ -84,15 +468,16 @@ func orphancheck() {
 func backendServer() {
 	dlog.Printf("backend server running")
 	go orphancheck()
-	shrinker := new(Shrinker)
 	srv := rpc.NewServer()
-	err := srv.Register(shrinker)
-	if err != nil {
-		elog.Panicf("unable to register shrinker: %s", err)
+	for _, svc := range []interface{}{new(Shrinker), new(Sanitizer), new(Hooter), new(Previewer)} {
+		err := srv.Register(svc)
+		if err != nil {
+			elog.Panicf("unable to register backend service: %s", err)
+		}
 	}
 
 	sockname := backendSockname()
-	err = os.Remove(sockname)
+	err := os.Remove(sockname)
 	if err != nil && !os.IsNotExist(err) {
 		elog.Panicf("unable to unlink socket: %s", err)
 	}
@@ -111,22 +496,60 @@ func backendServer() {
 	srv.Accept(lis)
 }
 
+// restart the backend if it dies, but not too eagerly
 func runBackendServer() {
+	started := make(chan bool)
+	go func() {
+		pause := time.Second
+		first := true
+		for {
+			began := time.Now()
+			proc, w, err := startbackend()
+			if first {
+				if err != nil {
+					elog.Panicf("can't exec backend: %s", err)
+				}
+				first = false
+				close(started)
+			}
+			if err == nil {
+				err = proc.Wait()
+				w.Close()
+			}
+			backendlock.Lock()
+			if backendclient != nil {
+				backendclient.Close()
+				backendclient = nil
+			}
+			backendlock.Unlock()
+			if time.Since(began) > time.Minute {
+				pause = time.Second
+			}
+			elog.Printf("lost the backend: %s, restarting in %s", err, pause)
+			time.Sleep(pause)
+			pause *= 2
+			if pause > time.Minute {
+				pause = time.Minute
+			}
+		}
+	}()
+	<-started
+}
+
+func startbackend() (*exec.Cmd, *os.File, error) {
 	r, w, err := os.Pipe()
 	if err != nil {
-		elog.Panicf("can't pipe: %s", err)
+		return nil, nil, err
 	}
 	proc := exec.Command(os.Args[0], reexecArgs("backend")...)
 	proc.Stdout = os.Stdout
 	proc.Stderr = os.Stderr
 	proc.Stdin = r
 	err = proc.Start()
+	r.Close()
 	if err != nil {
-		elog.Panicf("can't exec backend: %s", err)
-	}
-	go func() {
-		proc.Wait()
-		elog.Printf("lost the backend: %s", err)
 		w.Close()
-	}()
+		return nil, nil, err
+	}
+	return proc, w, nil
 }
@@ -65,7 +65,7 @@ func svalbard(dirname string) {
 	}
 	rows.Close()
 
-	rows = qordie(orig, "select convoy from honks where flags & 4 or whofore = 2 or whofore = 3")
+	rows = qordie(orig, "select convoy from honks where flags & 4 or whofore = 2 or whofore = 3 or whofore = 4")
 	convoys := make(map[string]bool)
 	for rows.Next() {
 		var convoy string
@@ -138,14 +138,14 @@ func svalbard(dirname string) {
 	}
 	filexids := make(map[string]bool)
 	for f := range fileids {
-		rows = qordie(orig, "select fileid, xid, name, description, url, media, local from filemeta where fileid = ?", f)
+		rows = qordie(orig, "select fileid, xid, name, description, url, media, local, meta from filemeta where fileid = ?", f)
 		for rows.Next() {
 			var fileid int64
-			var xid, name, description, url, media string
+			var xid, name, description, url, media, meta string
 			var local int64
-			scanordie(rows, &fileid, &xid, &name, &description, &url, &media, &local)
+			scanordie(rows, &fileid, &xid, &name, &description, &url, &media, &local, &meta)
 			filexids[xid] = true
-			doordie(tx, "insert into filemeta (fileid, xid, name, description, url, media, local) values (?, ?, ?, ?, ?, ?, ?)", fileid, xid, name, description, url, media, local)
+			doordie(tx, "insert into filemeta (fileid, xid, name, description, url, media, local, meta) values (?, ?, ?, ?, ?, ?, ?, ?)", fileid, xid, name, description, url, media, local, meta)
 		}
 		rows.Close()
 	}
@@ -162,6 +162,7 @@ func svalbard(dirname string) {
 	if err != nil {
 		elog.Fatalf("can't commit backp: %s", err)
 	}
+	reindexhonks(backup)
 	backup.Close()
 
 	backupblobname := fmt.Sprintf("%s/blob-%d.db", dirname, now)
@@ -172,20 +173,49 @@ func svalbard(dirname string) {
 	doordie(blob, "create table filedata (xid text, media text, hash text, content blob)")
 	doordie(blob, "create index idx_filexid on filedata(xid)")
 	doordie(blob, "create index idx_filehash on filedata(hash)")
+	doordie(blob, "create table filevariants (xid text, size integer, media text, hash text, content blob)")
+	doordie(blob, "create index idx_variantxid on filevariants(xid)")
 	tx, err = blob.Begin()
 	if err != nil {
 		elog.Fatalf("can't start transaction: %s", err)
 	}
+	// the backup is self contained, whichever store the files live in
+	carry := func(what string, hash string, content []byte) []byte {
+		if len(content) > 0 || hash == "" {
+			return content
+		}
+		data, err := blobs.Get(hash)
+		if err == errNoBlob {
+			ilog.Printf("missing %s %s, not backed up", what, hash)
+			return nil
+		}
+		if err != nil {
+			elog.Fatalf("can't back up %s %s: %s", what, hash, err)
+		}
+		return data
+	}
 	origblob := openblobdb()
 	for x := range filexids {
 		rows = qordie(origblob, "select xid, media, hash, content from filedata where xid = ?", x)
 		for rows.Next() {
 			var xid, media, hash string
-			var content sql.RawBytes
+			var content []byte
 			scanordie(rows, &xid, &media, &hash, &content)
+			content = carry("file", hash, content)
 			doordie(tx, "insert into filedata (xid, media, hash, content) values (?, ?, ?, ?)", xid, media, hash, content)
 		}
 		rows.Close()
+		rows = qordie(origblob, "select xid, size, media, hash, content from filevariants where xid = ?", x)
+		for rows.Next() {
+			var xid, media string
+			var size int64
+			var hash sql.NullString
+			var content []byte
+			scanordie(rows, &xid, &size, &media, &hash, &content)
+			content = carry("variant", hash.String, content)
+			doordie(tx, "insert into filevariants (xid, size, media, hash, content) values (?, ?, ?, ?, ?)", xid, size, media, hash, content)
+		}
+		rows.Close()
 	}
 
 	err = tx.Commit()
@@ -0,0 +1,426 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The filedata table in blob.db always knows the xid, media, and hash
+// of every file. Where the bytes live depends on the store.
+type BlobStore interface {
+	Put(hash string, data []byte) error
+	Get(hash string) ([]byte, error)
+	Delete(hash string) error
+}
+
+var blobStoreName = "sqlite"
+var blobDir = ""
+var s3Endpoint = ""
+var s3Bucket = ""
+var s3Region = "us-east-1"
+var s3AccessKey = ""
+var s3SecretKey = ""
+
+var blobs BlobStore
+
+var errNoBlob = errors.New("no such blob")
+
+func openblobstore(name string) BlobStore {
+	switch name {
+	case "sqlite":
+		return new(sqliteBlobs)
+	case "files":
+		dir := blobDir
+		if dir == "" {
+			dir = dataDir + "/blobs"
+		}
+		return &fileBlobs{dir: dir}
+	case "s3":
+		if s3Endpoint == "" || s3Bucket == "" {
+			elog.Fatal("s3 blob store needs s3endpoint and s3bucket")
+		}
+		return &s3Blobs{
+			endpoint: s3Endpoint,
+			bucket:   s3Bucket,
+			region:   s3Region,
+			access:   s3AccessKey,
+			secret:   s3SecretKey,
+		}
+	}
+	elog.Fatalf("unknown blob store: %s", name)
+	return nil
+}
+
+func saveblob(xid string, media string, data []byte) error {
+	hash := hashfiledata(data)
+	_, err := stmtSaveFileData.Exec(xid, media, hash, nil)
+	if err != nil {
+		return err
+	}
+	err = blobs.Put(hash, data)
+	if err != nil {
+		stmtZapFileData.Exec(xid)
+	}
+	return err
+}
+
+func loadblob(xid string) (string, []byte, error) {
+	var media, hash string
+	var content []byte
+	row := stmtGetFileData.QueryRow(xid)
+	err := row.Scan(&media, &hash, &content)
+	if err != nil {
+		return "", nil, err
+	}
+	// not yet migrated out of sqlite
+	if len(content) > 0 {
+		return media, content, nil
+	}
+	data, err := blobs.Get(hash)
+	if err == errNoBlob {
+		return "", nil, sql.ErrNoRows
+	}
+	return media, data, err
+}
+
+// the same bytes may be shared by several xids
+func zapblob(xid string) error {
+	var hash string
+	row := stmtFileDataHash.QueryRow(xid)
+	err := row.Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stmtZapFileData.Exec(xid)
+	if err != nil {
+		return err
+	}
+	return zaphash(hash)
+}
+
+// only once nothing else uses it
+func zaphash(hash string) error {
+	var n int64
+	row := stmtCountFileHash.QueryRow(hash, hash)
+	err := row.Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	err = blobs.Delete(hash)
+	if err == errNoBlob {
+		err = nil
+	}
+	return err
+}
+
+// variants are made along with the file, and kept in the same store
+func savevariants(xid string, meta DonkMeta) {
+	for i, img := range meta.variants {
+		hash := hashfiledata(img.Data)
+		_, err := stmtSaveVariant.Exec(xid, meta.Variants[i], "image/"+img.Format, hash, nil)
+		if err == nil {
+			err = blobs.Put(hash, img.Data)
+		}
+		if err != nil {
+			elog.Printf("error saving variant: %s", err)
+		}
+	}
+}
+
+func getvariant(xid string, size int) (string, []byte, bool) {
+	var media string
+	var hash sql.NullString
+	var content []byte
+	row := stmtGetVariant.QueryRow(xid, size)
+	err := row.Scan(&media, &hash, &content)
+	if err == nil && len(content) == 0 {
+		content, err = blobs.Get(hash.String)
+	}
+	if err == nil {
+		return media, content, true
+	}
+	if err != sql.ErrNoRows && err != errNoBlob {
+		elog.Printf("error loading variant: %s", err)
+	}
+	return "", nil, false
+}
+
+func zapvariants(xid string) error {
+	var hashes []string
+	rows, err := stmtVariantHashes.Query(xid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	_, err = stmtZapVariants.Exec(xid)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		err = zaphash(hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqliteBlobs struct {
+}
+
+// a hash may belong to a file or a variant
+func (s *sqliteBlobs) Put(hash string, data []byte) error {
+	_, err := stmtPutBlobContent.Exec(data, hash)
+	if err == nil {
+		_, err = stmtPutVariantContent.Exec(data, hash)
+	}
+	return err
+}
+
+func (s *sqliteBlobs) Get(hash string) ([]byte, error) {
+	var data []byte
+	row := stmtGetBlobContent.QueryRow(hash, hash)
+	err := row.Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errNoBlob
+	}
+	return data, err
+}
+
+func (s *sqliteBlobs) Delete(hash string) error {
+	_, err := stmtPutBlobContent.Exec(nil, hash)
+	if err == nil {
+		_, err = stmtPutVariantContent.Exec(nil, hash)
+	}
+	return err
+}
+
+type fileBlobs struct {
+	dir string
+}
+
+func (f *fileBlobs) path(hash string) string {
+	if len(hash) < 4 {
+		return filepath.Join(f.dir, hash)
+	}
+	return filepath.Join(f.dir, hash[0:2], hash[2:4], hash)
+}
+
+func (f *fileBlobs) Put(hash string, data []byte) error {
+	fname := f.path(hash)
+	if _, err := os.Stat(fname); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fname), ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *fileBlobs) Get(hash string) ([]byte, error) {
+	data, err := os.ReadFile(f.path(hash))
+	if os.IsNotExist(err) {
+		return nil, errNoBlob
+	}
+	return data, err
+}
+
+func (f *fileBlobs) Delete(hash string) error {
+	err := os.Remove(f.path(hash))
+	if os.IsNotExist(err) {
+		return errNoBlob
+	}
+	return err
+}
+
+// path style requests, so minio and friends work too
+type s3Blobs struct {
+	endpoint string
+	bucket   string
+	region   string
+	access   string
+	secret   string
+}
+
+func sha256hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return fmt.Sprintf("%x", h[:])
+}
+
+func hmacsha256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+func s3signingkey(secret, day, region, service string) []byte {
+	key := hmacsha256([]byte("AWS4"+secret), day)
+	key = hmacsha256(key, region)
+	key = hmacsha256(key, service)
+	return hmacsha256(key, "aws4_request")
+}
+
+// signature version 4, signing only what we send
+func (s *s3Blobs) sign(req *http.Request, payload string, now time.Time) {
+	amzdate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzdate)
+	req.Header.Set("x-amz-content-sha256", payload)
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canon := fmt.Sprintf("%s\n%s\n\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n\n%s\n%s",
+		req.Method, req.URL.EscapedPath(), req.URL.Host, payload, amzdate, signed, payload)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, s.region)
+	tosign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", amzdate, scope, sha256hex([]byte(canon)))
+	key := s3signingkey(s.secret, day, s.region, "s3")
+	sig := fmt.Sprintf("%x", hmacsha256(key, tosign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access, scope, signed, sig))
+}
+
+func (s *s3Blobs) do(method string, hash string, data []byte) ([]byte, error) {
+	url := fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, hash)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, sha256hex(data), time.Now().UTC())
+	if method == "PUT" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200, 204:
+	case 404:
+		return nil, errNoBlob
+	default:
+		return nil, fmt.Errorf("s3 %s %s: status %d", method, hash, resp.StatusCode)
+	}
+	if method != "GET" {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	return buf.Bytes(), err
+}
+
+func (s *s3Blobs) Put(hash string, data []byte) error {
+	_, err := s.do("PUT", hash, data)
+	return err
+}
+
+func (s *s3Blobs) Get(hash string) ([]byte, error) {
+	return s.do("GET", hash, nil)
+}
+
+func (s *s3Blobs) Delete(hash string) error {
+	_, err := s.do("DELETE", hash, nil)
+	return err
+}
+
+func migrateblobs(from, to string) {
+	if from == to {
+		elog.Fatal("nothing to migrate")
+	}
+	src := openblobstore(from)
+	dst := openblobstore(to)
+	blobdb := openblobdb()
+	rows, err := blobdb.Query("select hash from filedata union select hash from filevariants where hash is not null")
+	if err != nil {
+		elog.Fatal(err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	moved := 0
+	for _, hash := range hashes {
+		data, err := src.Get(hash)
+		if err == errNoBlob {
+			continue
+		}
+		if err != nil {
+			elog.Fatalf("error reading %s: %s", hash, err)
+		}
+		err = dst.Put(hash, data)
+		if err != nil {
+			elog.Fatalf("error writing %s: %s", hash, err)
+		}
+		err = src.Delete(hash)
+		if err != nil && err != errNoBlob {
+			elog.Printf("error removing %s: %s", hash, err)
+		}
+		moved++
+	}
+	setconfig("blobstore", to)
+	fmt.Printf("moved %d files from %s to %s\n", moved, from, to)
+	if from == "sqlite" {
+		fmt.Printf("run sqlite3 blob.db vacuum to reclaim space\n")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestS3SigningKey(t *testing.T) {
+	// the example from the AWS signature version 4 documentation
+	key := s3signingkey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got := fmt.Sprintf("%x", key); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestS3Sign(t *testing.T) {
+	s := &s3Blobs{
+		region: "us-east-1",
+		access: "AKIDEXAMPLE",
+		secret: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	req, _ := http.NewRequest("PUT", "http://minio.example:9000/honk/abcd1234", strings.NewReader("hello"))
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	s.sign(req, sha256hex([]byte("hello")), now)
+
+	if got := req.Header.Get("x-amz-date"); got != "20150830T123600Z" {
+		t.Errorf("date: got %s", got)
+	}
+	if got := req.Header.Get("x-amz-content-sha256"); got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("payload: got %s", got)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=fd531f59844d85f69b69239e51f7e70e05b9e8bf3414798d26c3b5b11f5ba3d6"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("authorization:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+// just enough of a bucket to hold some blobs
+func fakes3() *httptest.Server {
+	var mtx sync.Mutex
+	objects := make(map[string][]byte)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+			http.Error(w, "who are you?", http.StatusForbidden)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/bucket/") {
+			http.NotFound(w, r)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("x-amz-content-sha256") != sha256hex(data) {
+			http.Error(w, "bad payload hash", http.StatusBadRequest)
+			return
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch r.Method {
+		case "PUT":
+			objects[r.URL.Path] = data
+		case "GET":
+			obj, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(obj)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "what?", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func testblobstore(t *testing.T, store BlobStore) {
+	data := []byte("some bytes to keep")
+	hash := hashfiledata(data)
+
+	if _, err := store.Get(hash); err != errNoBlob {
+		t.Fatalf("get before put: got %v", err)
+	}
+	if err := store.Put(hash, data); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	// putting the same thing twice is fine
+	if err := store.Put(hash, data); err != nil {
+		t.Fatalf("second put: %s", err)
+	}
+	got, err := store.Get(hash)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("get: got %q want %q", got, data)
+	}
+	if err := store.Delete(hash); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if _, err := store.Get(hash); err != errNoBlob {
+		t.Errorf("get after delete: got %v", err)
+	}
+}
+
+func TestS3Blobs(t *testing.T) {
+	srv := fakes3()
+	defer srv.Close()
+	testblobstore(t, &s3Blobs{
+		endpoint: srv.URL,
+		bucket:   "bucket",
+		region:   "us-east-1",
+		access:   "access",
+		secret:   "secret",
+	})
+}
+
+func TestFileBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store := &fileBlobs{dir: dir}
+	testblobstore(t, store)
+	if err := store.Delete(hashfiledata([]byte("never saved"))); err != errNoBlob {
+		t.Errorf("delete missing: got %v", err)
+	}
+}
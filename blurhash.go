@@ -0,0 +1,152 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	goimage "image"
+	"math"
+	"strings"
+)
+
+// see https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+
+const blurChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// don't look at every pixel of a big picture
+const blurSamples = 64
+
+func blur83(sb *strings.Builder, value int, length int) {
+	div := 1
+	for i := 1; i < length; i++ {
+		div *= 83
+	}
+	for i := 0; i < length; i++ {
+		sb.WriteByte(blurChars[(value/div)%83])
+		div /= 83
+	}
+}
+
+func srgbtolinear(v uint32) float64 {
+	f := float64(v>>8) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func lineartosrgb(f float64) int {
+	f = math.Max(0, math.Min(1, f))
+	if f <= 0.0031308 {
+		return int(f*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(f, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signpow(f float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(f), exp), f)
+}
+
+func blurhash(img goimage.Image, xcomps, ycomps int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+	sw, sh := width, height
+	if sw > blurSamples {
+		sw = blurSamples
+	}
+	if sh > blurSamples {
+		sh = blurSamples
+	}
+	// sample once, in linear space
+	pixels := make([][3]float64, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x*width/sw, bounds.Min.Y+y*height/sh).RGBA()
+			pixels[y*sw+x] = [3]float64{srgbtolinear(r), srgbtolinear(g), srgbtolinear(b)}
+		}
+	}
+	factors := make([][3]float64, 0, xcomps*ycomps)
+	for j := 0; j < ycomps; j++ {
+		for i := 0; i < xcomps; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1.0
+			}
+			var f [3]float64
+			for y := 0; y < sh; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(sh))
+				for x := 0; x < sw; x++ {
+					basis := cy * math.Cos(math.Pi*float64(i)*float64(x)/float64(sw))
+					p := pixels[y*sw+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := norm / float64(sw*sh)
+			f[0] *= scale
+			f[1] *= scale
+			f[2] *= scale
+			factors = append(factors, f)
+		}
+	}
+
+	var sb strings.Builder
+	blur83(&sb, (xcomps-1)+(ycomps-1)*9, 1)
+	maxval := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			for _, c := range f {
+				actual = math.Max(actual, math.Abs(c))
+			}
+		}
+		quant := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxval = float64(quant+1) / 166
+		blur83(&sb, quant, 1)
+	} else {
+		blur83(&sb, 0, 1)
+	}
+	dc := factors[0]
+	blur83(&sb, lineartosrgb(dc[0])<<16+lineartosrgb(dc[1])<<8+lineartosrgb(dc[2]), 4)
+	for _, f := range factors[1:] {
+		var q [3]int
+		for k, c := range f {
+			q[k] = int(math.Max(0, math.Min(18, math.Floor(signpow(c/maxval, 0.5)*9+9.5))))
+		}
+		blur83(&sb, q[0]*19*19+q[1]*19+q[2], 2)
+	}
+	return sb.String()
+}
+
+// average color, good enough when scripts don't run
+func (meta DonkMeta) Color() string {
+	if len(meta.Blurhash) < 6 {
+		return ""
+	}
+	dc := 0
+	for _, c := range meta.Blurhash[2:6] {
+		idx := strings.IndexRune(blurChars, c)
+		if idx == -1 {
+			return ""
+		}
+		dc = dc*83 + idx
+	}
+	return fmt.Sprintf("#%06x", dc&0xffffff)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testpic(w, h int, fn func(x, y int) color.RGBA) image.Image {
+	pic := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pic.Set(x, y, fn(x, y))
+		}
+	}
+	return pic
+}
+
+// expected hashes follow the woltapp reference encoder (C/encode.c),
+// with pictures small enough that every pixel is sampled
+func TestBlurhash(t *testing.T) {
+	tests := []struct {
+		name string
+		pic  image.Image
+		want string
+	}{
+		{"gradient", testpic(8, 6, func(x, y int) color.RGBA {
+			return color.RGBA{uint8(x * 32), uint8(y * 40), uint8(255 - (x+y)*16), 255}
+		}), "LuF=a_7Qb2xdvIR=fTnnevfAfRf9"},
+		{"solid", testpic(5, 5, func(x, y int) color.RGBA {
+			return color.RGBA{200, 100, 50, 255}
+		}), "LbM|T9}XfQ}X}XxFfQxFfQfQfQfQ"},
+	}
+	for _, test := range tests {
+		if got := blurhash(test.pic, 4, 3); got != test.want {
+			t.Errorf("%s: got %s want %s", test.name, got, test.want)
+		}
+	}
+	if got := blurhash(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3); got != "" {
+		t.Errorf("empty: got %s", got)
+	}
+}
+
+func TestDonkMetaColor(t *testing.T) {
+	tests := []struct {
+		hash string
+		want string
+	}{
+		{"LbM|T9}XfQ}X}XxFfQxFfQfQfQfQ", "#c86432"},
+		{"LuF=a_7Qb2xdvIR=fTnnevfAfRf9", "#8a7ca7"},
+		{"L00000", "#000000"},
+		{"", ""},
+		{"LbM|T", ""},
+		{"Lb\"|T9", ""},
+	}
+	for _, test := range tests {
+		meta := DonkMeta{Blurhash: test.hash}
+		if got := meta.Color(); got != test.want {
+			t.Errorf("%q: got %s want %s", test.hash, got, test.want)
+		}
+	}
+}
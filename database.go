@@ -180,6 +180,11 @@ func getxonk(userid int64, xid string) *Honk {
 	return scanhonk(row)
 }
 
+func gethonkbyid(userid int64, honkid int64) *Honk {
+	row := stmtOneHonkByID.QueryRow(userid, honkid)
+	return scanhonk(row)
+}
+
 func getbonk(userid int64, xid string) *Honk {
 	row := stmtOneBonk.QueryRow(userid, xid)
 	return scanhonk(row)
@@ -236,6 +241,12 @@ func gethonksforuser(userid int64, wanted int64) []*Honk {
 	rows, err := stmtHonksForUser.Query(wanted, userid, dt, userid, userid)
 	return getsomehonks(rows, err)
 }
+
+// no time window, so clients can page back as far as they like
+func gethonksforuserbetween(userid int64, wanted int64, before int64) []*Honk {
+	rows, err := stmtHonksForUserBetween.Query(wanted, before, userid, userid, userid)
+	return getsomehonks(rows, err)
+}
 func gethonksforuserfirstclass(userid int64, wanted int64) []*Honk {
 	dt := time.Now().Add(-7 * 24 * time.Hour).UTC().Format(dbtimeformat)
 	rows, err := stmtHonksForUserFirstClass.Query(wanted, userid, dt, userid, userid)
@@ -282,59 +293,6 @@ func gethonksbyconvoy(userid int64, convoy string, wanted int64) []*Honk {
 	honks := getsomehonks(rows, err)
 	return honks
 }
-func gethonksbysearch(userid int64, q string, wanted int64) []*Honk {
-	var queries []string
-	var params []interface{}
-	queries = append(queries, "honks.honkid > ?")
-	params = append(params, wanted)
-	queries = append(queries, "honks.userid = ?")
-	params = append(params, userid)
-
-	terms := strings.Split(q, " ")
-	for _, t := range terms {
-		if t == "" {
-			continue
-		}
-		negate := " "
-		if t[0] == '-' {
-			t = t[1:]
-			negate = " not "
-		}
-		if t == "" {
-			continue
-		}
-		if strings.HasPrefix(t, "site:") {
-			site := t[5:]
-			site = "%" + site + "%"
-			queries = append(queries, "xid"+negate+"like ?")
-			params = append(params, site)
-			continue
-		}
-		if strings.HasPrefix(t, "honker:") {
-			honker := t[7:]
-			xid := fullname(honker, userid)
-			if xid != "" {
-				honker = xid
-			}
-			queries = append(queries, negate+"(honks.honker = ? or honks.oonker = ?)")
-			params = append(params, honker)
-			params = append(params, honker)
-			continue
-		}
-		t = "%" + t + "%"
-		queries = append(queries, "noise"+negate+"like ?")
-		params = append(params, t)
-	}
-
-	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, noise, precis, format, convoy, whofore, flags from honks join users on honks.userid = users.userid "
-	where := "where " + strings.Join(queries, " and ")
-	butnotthose := " and convoy not in (select name from zonkers where userid = ? and wherefore = 'zonvoy' order by zonkerid desc limit 100)"
-	limit := " order by honks.honkid desc limit 250"
-	params = append(params, userid)
-	rows, err := opendatabase().Query(selecthonks+where+butnotthose+limit, params...)
-	honks := getsomehonks(rows, err)
-	return honks
-}
 func gethonksbyontology(userid int64, name string, wanted int64) []*Honk {
 	rows, err := stmtHonksByOntology.Query(wanted, name, userid, userid)
 	honks := getsomehonks(rows, err)
@@ -396,7 +354,7 @@ func donksforhonks(honks []*Honk) {
 	}
 	idset := strings.Join(ids, ",")
 	// grab donks
-	q := fmt.Sprintf("select honkid, donks.fileid, xid, name, description, url, media, local from donks join filemeta on donks.fileid = filemeta.fileid where honkid in (%s)", idset)
+	q := fmt.Sprintf("select honkid, donks.fileid, xid, name, description, url, media, local, meta from donks join filemeta on donks.fileid = filemeta.fileid where honkid in (%s) order by donks.rowid", idset)
 	rows, err := db.Query(q)
 	if err != nil {
 		elog.Printf("error querying donks: %s", err)
@@ -405,8 +363,12 @@ func donksforhonks(honks []*Honk) {
 	defer rows.Close()
 	for rows.Next() {
 		var hid int64
+		var meta string
 		d := new(Donk)
-		err = rows.Scan(&hid, &d.FileID, &d.XID, &d.Name, &d.Desc, &d.URL, &d.Media, &d.Local)
+		err = rows.Scan(&hid, &d.FileID, &d.XID, &d.Name, &d.Desc, &d.URL, &d.Media, &d.Local, &meta)
+		if err == nil {
+			err = unjsonify(meta, &d.Meta)
+		}
 		if err != nil {
 			elog.Printf("error scanning donk: %s", err)
 			continue
@@ -488,7 +450,12 @@ func donksforhonks(honks []*Honk) {
 			h.Wonkles = j
 		case "guesses":
 			h.Guesses = template.HTML(j)
+		case "lang":
+			h.Lang = j
+		case "expires":
+			h.Expires, _ = time.Parse(dbtimeformat, j)
 		case "oldrev":
+			h.Edited = true
 		default:
 			elog.Printf("unknown meta genus: %s", genus)
 		}
@@ -506,7 +473,7 @@ func donksforchonks(chonks []*Chonk) {
 	}
 	idset := strings.Join(ids, ",")
 	// grab donks
-	q := fmt.Sprintf("select chonkid, donks.fileid, xid, name, description, url, media, local from donks join filemeta on donks.fileid = filemeta.fileid where chonkid in (%s)", idset)
+	q := fmt.Sprintf("select chonkid, donks.fileid, xid, name, description, url, media, local, meta from donks join filemeta on donks.fileid = filemeta.fileid where chonkid in (%s) order by donks.rowid", idset)
 	rows, err := db.Query(q)
 	if err != nil {
 		elog.Printf("error querying donks: %s", err)
@@ -515,8 +482,12 @@ func donksforchonks(chonks []*Chonk) {
 	defer rows.Close()
 	for rows.Next() {
 		var chid int64
+		var meta string
 		d := new(Donk)
-		err = rows.Scan(&chid, &d.FileID, &d.XID, &d.Name, &d.Desc, &d.URL, &d.Media, &d.Local)
+		err = rows.Scan(&chid, &d.FileID, &d.XID, &d.Name, &d.Desc, &d.URL, &d.Media, &d.Local, &meta)
+		if err == nil {
+			err = unjsonify(meta, &d.Meta)
+		}
 		if err != nil {
 			elog.Printf("error scanning donk: %s", err)
 			continue
@@ -526,8 +497,8 @@ func donksforchonks(chonks []*Chonk) {
 	}
 }
 
-func savefile(name string, desc string, url string, media string, local bool, data []byte) (int64, error) {
-	fileid, _, err := savefileandxid(name, desc, url, media, local, data)
+func savefile(name string, desc string, url string, media string, local bool, data []byte, meta DonkMeta) (int64, error) {
+	fileid, _, err := savefileandxid(name, desc, url, media, local, data, meta)
 	return fileid, err
 }
 
@@ -537,7 +508,7 @@ func hashfiledata(data []byte) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func savefileandxid(name string, desc string, url string, media string, local bool, data []byte) (int64, string, error) {
+func savefileandxid(name string, desc string, url string, media string, local bool, data []byte, meta DonkMeta) (int64, string, error) {
 	var xid string
 	if local {
 		hash := hashfiledata(data)
@@ -554,11 +525,23 @@ func savefileandxid(name string, desc string, url string, media string, local bo
 				xid += ".pdf"
 			case "text/plain":
 				xid += ".txt"
+			case "video/mp4":
+				xid += ".mp4"
+			case "video/webm":
+				xid += ".webm"
+			case "audio/mpeg":
+				xid += ".mp3"
+			case "audio/ogg":
+				xid += ".ogg"
 			}
-			_, err = stmtSaveFileData.Exec(xid, media, hash, data)
+			err = saveblob(xid, media, data)
 			if err != nil {
 				return 0, "", err
 			}
+			savevariants(xid, meta)
+			if url != "" && !strings.HasPrefix(url, serverPrefix) {
+				cachefile(xid, len(data))
+			}
 		} else if err != nil {
 			elog.Printf("error checking file hash: %s", err)
 			return 0, "", err
@@ -568,7 +551,11 @@ func savefileandxid(name string, desc string, url string, media string, local bo
 		}
 	}
 
-	res, err := stmtSaveFile.Exec(xid, name, desc, url, media, local)
+	j, err := jsonify(meta)
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := stmtSaveFile.Exec(xid, name, desc, url, media, local, j)
 	if err != nil {
 		return 0, "", err
 	}
@@ -576,10 +563,33 @@ func savefileandxid(name string, desc string, url string, media string, local bo
 	return fileid, xid, nil
 }
 
+// a file shared with anything else gets a copy of its own to describe.
+// call before saving the donk, so it gets the right file.
+func updatedonkdesc(tx *sql.Tx, honkid int64, d *Donk) error {
+	var others int64
+	row := tx.Stmt(stmtFileShared).QueryRow(d.FileID, honkid)
+	err := row.Scan(&others)
+	if err == nil {
+		if others == 0 {
+			_, err = tx.Stmt(stmtUpdateFileDesc).Exec(d.Desc, d.FileID)
+		} else {
+			var res sql.Result
+			res, err = tx.Stmt(stmtCopyFile).Exec(d.Desc, d.FileID)
+			if err == nil {
+				d.FileID, _ = res.LastInsertId()
+			}
+		}
+	}
+	if err != nil {
+		elog.Printf("error updating file description: %s", err)
+	}
+	return err
+}
+
 func finddonk(url string) *Donk {
 	donk := new(Donk)
 	row := stmtFindFile.QueryRow(url)
-	err := row.Scan(&donk.FileID, &donk.XID)
+	err := row.Scan(&donk.FileID, &donk.XID, &donk.Desc)
 	if err == nil {
 		return donk
 	}
@@ -613,85 +623,94 @@ func savechonk(ch *Chonk) error {
 	} else {
 		tx.Rollback()
 	}
+	if err == nil {
+		streamchonk(ch)
+	}
 	return err
 }
 
-func chatplusone(tx *sql.Tx, userid int64) {
+// the menu counters all live in the user options
+func updatecounter(ex dbexecer, userid int64, what string, change func(options *UserOptions) bool) bool {
 	var user *WhatAbout
 	ok := somenumberedusers.Get(userid, &user)
 	if !ok {
-		return
+		return false
 	}
 	options := user.Options
-	options.ChatCount += 1
+	if !change(&options) {
+		return false
+	}
 	j, err := jsonify(options)
 	if err == nil {
-		_, err = tx.Exec("update users set options = ? where username = ?", j, user.Name)
+		_, err = ex.Exec("update users set options = ? where username = ?", j, user.Name)
 	}
 	if err != nil {
-		elog.Printf("error plussing chat: %s", err)
+		elog.Printf("error updating %s count: %s", what, err)
 	}
 	somenamedusers.Clear(user.Name)
 	somenumberedusers.Clear(user.ID)
+	return err == nil
+}
+
+func chatplusone(tx *sql.Tx, userid int64) {
+	updatecounter(tx, userid, "chat", func(options *UserOptions) bool {
+		options.ChatCount += 1
+		return true
+	})
 }
 
 func chatnewnone(userid int64) {
-	var user *WhatAbout
-	ok := somenumberedusers.Get(userid, &user)
-	if !ok || user.Options.ChatCount == 0 {
-		return
-	}
-	options := user.Options
-	options.ChatCount = 0
-	j, err := jsonify(options)
-	if err == nil {
-		db := opendatabase()
-		_, err = db.Exec("update users set options = ? where username = ?", j, user.Name)
-	}
-	if err != nil {
-		elog.Printf("error noneing chat: %s", err)
+	cleared := updatecounter(opendatabase(), userid, "chat", func(options *UserOptions) bool {
+		if options.ChatCount == 0 {
+			return false
+		}
+		options.ChatCount = 0
+		return true
+	})
+	if cleared {
+		streamcounts(userid)
 	}
-	somenamedusers.Clear(user.Name)
-	somenumberedusers.Clear(user.ID)
 }
 
 func meplusone(tx *sql.Tx, userid int64) {
-	var user *WhatAbout
-	ok := somenumberedusers.Get(userid, &user)
-	if !ok {
-		return
-	}
-	options := user.Options
-	options.MeCount += 1
-	j, err := jsonify(options)
-	if err == nil {
-		_, err = tx.Exec("update users set options = ? where username = ?", j, user.Name)
-	}
-	if err != nil {
-		elog.Printf("error plussing me: %s", err)
-	}
-	somenamedusers.Clear(user.Name)
-	somenumberedusers.Clear(user.ID)
+	updatecounter(tx, userid, "me", func(options *UserOptions) bool {
+		options.MeCount += 1
+		return true
+	})
 }
 
 func menewnone(userid int64) {
-	var user *WhatAbout
-	ok := somenumberedusers.Get(userid, &user)
-	if !ok || user.Options.MeCount == 0 {
-		return
-	}
-	options := user.Options
-	options.MeCount = 0
-	j, err := jsonify(options)
-	if err == nil {
-		db := opendatabase()
-		_, err = db.Exec("update users set options = ? where username = ?", j, user.Name)
+	cleared := updatecounter(opendatabase(), userid, "me", func(options *UserOptions) bool {
+		if options.MeCount == 0 {
+			return false
+		}
+		options.MeCount = 0
+		return true
+	})
+	if cleared {
+		streamcounts(userid)
 	}
-	if err != nil {
-		elog.Printf("error noneing me: %s", err)
+}
+
+func noteplusone(userid int64) {
+	updatecounter(opendatabase(), userid, "note", func(options *UserOptions) bool {
+		options.NoteCount += 1
+		return true
+	})
+	streamcounts(userid)
+}
+
+func notenewnone(userid int64) {
+	cleared := updatecounter(opendatabase(), userid, "note", func(options *UserOptions) bool {
+		if options.NoteCount == 0 {
+			return false
+		}
+		options.NoteCount = 0
+		return true
+	})
+	if cleared {
+		streamcounts(userid)
 	}
-	somenamedusers.Clear(user.Name)
-	somenumberedusers.Clear(user.ID)
 }
 
 func loadchatter(userid int64) []*Chatter {
@@ -774,6 +793,9 @@ func savehonk(h *Honk) error {
 		h.ID, _ = res.LastInsertId()
 		err = saveextras(tx, h)
 	}
+	if err == nil {
+		err = indexhonk(tx, h)
+	}
 	if err == nil {
 		if h.Whofore == 1 {
 			meplusone(tx, h.UserID)
@@ -784,6 +806,9 @@ func savehonk(h *Honk) error {
 	}
 	if err != nil {
 		elog.Printf("error saving honk: %s", err)
+	} else {
+		streamhonk(h)
+		searchInvalidator.Clear(h.UserID)
 	}
 	honkhonkline()
 	return err
@@ -791,7 +816,9 @@ func savehonk(h *Honk) error {
 
 func updatehonk(h *Honk) error {
 	old := getxonk(h.UserID, h.XID)
-	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise}
+	oldrev := OldRevision{Precis: old.Precis, Noise: old.Noise, Date: old.Date}
+	// nobody saw a scheduled honk, and some updates don't touch the text
+	keeprev := old.Whofore != 4 && (old.Precis != h.Precis || old.Noise != h.Noise)
 	dt := h.Date.UTC().Format(dbtimeformat)
 
 	db := opendatabase()
@@ -809,11 +836,17 @@ func updatehonk(h *Honk) error {
 		err = saveextras(tx, h)
 	}
 	if err == nil {
+		err = indexhonk(tx, h)
+	}
+	if err == nil && keeprev {
 		var j string
 		j, err = jsonify(&oldrev)
 		if err == nil {
 			_, err = tx.Stmt(stmtSaveMeta).Exec(old.ID, "oldrev", j)
 		}
+		if err == nil {
+			_, err = tx.Stmt(stmtTrimRevisions).Exec(old.ID, old.ID, maxRevisions)
+		}
 		if err != nil {
 			elog.Printf("error saving oldrev: %s", err)
 		}
@@ -841,6 +874,9 @@ func deletehonk(honkid int64) error {
 	if err == nil {
 		_, err = tx.Stmt(stmtDeleteHonk).Exec(honkid)
 	}
+	if err == nil {
+		_, err = tx.Stmt(stmtUnindexHonk).Exec(honkid)
+	}
 	if err == nil {
 		err = tx.Commit()
 	} else {
@@ -854,6 +890,12 @@ func deletehonk(honkid int64) error {
 
 func saveextras(tx *sql.Tx, h *Honk) error {
 	for _, d := range h.Donks {
+		if d.redesc {
+			err := updatedonkdesc(tx, h.ID, d)
+			if err != nil {
+				return err
+			}
+		}
 		_, err := tx.Stmt(stmtSaveDonk).Exec(h.ID, -1, d.FileID)
 		if err != nil {
 			elog.Printf("error saving donk: %s", err)
@@ -911,6 +953,20 @@ func saveextras(tx *sql.Tx, h *Honk) error {
 			return err
 		}
 	}
+	if l := h.Lang; l != "" {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "lang", l)
+		if err != nil {
+			elog.Printf("error saving lang: %s", err)
+			return err
+		}
+	}
+	if !h.Expires.IsZero() {
+		_, err := tx.Stmt(stmtSaveMeta).Exec(h.ID, "expires", h.Expires.UTC().Format(dbtimeformat))
+		if err != nil {
+			elog.Printf("error saving expires: %s", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -930,6 +986,7 @@ func addreaction(user *WhatAbout, xid string, who, react string) {
 	_, _ = tx.Stmt(stmtDeleteOneMeta).Exec(h.ID, "badonks")
 	_, _ = tx.Stmt(stmtSaveMeta).Exec(h.ID, "badonks", j)
 	tx.Commit()
+	notify(user, "react", who, xid, react)
 }
 
 func deleteextras(tx *sql.Tx, honkid int64, everything bool) error {
@@ -1024,6 +1081,59 @@ func savehonker(user *WhatAbout, url, name, flavor, combos, mj string) error {
 	return nil
 }
 
+// files without a donk that still have somebody holding on to them
+func keptfiles(db *sql.DB) map[string]bool {
+	keep := make(map[string]bool)
+	rows, err := db.Query("select json from drafts")
+	if err != nil {
+		elog.Fatal(err)
+	}
+	for rows.Next() {
+		var j string
+		err = rows.Scan(&j)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		var d Draft
+		err = unjsonify(j, &d)
+		if err != nil {
+			elog.Printf("error parsing draft: %s", err)
+			continue
+		}
+		for _, xid := range d.DonkXIDs {
+			if xid != "" {
+				keep[xid] = true
+			}
+		}
+	}
+	rows.Close()
+	// as do profiles, for their avatars and headers
+	rows, err = db.Query("select info from xonkers where flavor = 'profile'")
+	if err != nil {
+		elog.Fatal(err)
+	}
+	for rows.Next() {
+		var j string
+		err = rows.Scan(&j)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		var p Profile
+		err = unjsonify(j, &p)
+		if err != nil {
+			elog.Printf("error parsing profile: %s", err)
+			continue
+		}
+		for _, xid := range []string{p.Avatar, p.Header} {
+			if xid != "" {
+				keep[xid] = true
+			}
+		}
+	}
+	rows.Close()
+	return keep
+}
+
 func cleanupdb(arg string) {
 	db := opendatabase()
 	days, err := strconv.Atoi(arg)
@@ -1037,22 +1147,47 @@ func cleanupdb(arg string) {
 		sqlargs = append(sqlargs, honker)
 	} else {
 		expdate := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UTC().Format(dbtimeformat)
-		where = "dt < ? and convoy not in (select convoy from honks where flags & 4 or whofore = 2 or whofore = 3)"
+		where = "dt < ? and convoy not in (select convoy from honks where flags & 4 or whofore = 2 or whofore = 3 or whofore = 4)"
 		sqlargs = append(sqlargs, expdate)
 	}
 	doordie(db, "delete from honks where flags & 4 = 0 and whofore = 0 and "+where, sqlargs...)
 	doordie(db, "delete from donks where honkid > 0 and honkid not in (select honkid from honks)")
 	doordie(db, "delete from onts where honkid not in (select honkid from honks)")
 	doordie(db, "delete from honkmeta where honkid not in (select honkid from honks)")
-
-	doordie(db, "delete from filemeta where fileid not in (select fileid from donks)")
-	for _, u := range allusers() {
-		doordie(db, "delete from zonkers where userid = ? and wherefore = 'zonvoy' and zonkerid < (select zonkerid from zonkers where userid = ? and wherefore = 'zonvoy' order by zonkerid desc limit 1 offset 200)", u.UserID, u.UserID)
+	doordie(db, "delete from honksearch where rowid not in (select honkid from honks)")
+
+	doordie(db, "delete from xonkers where flavor = 'profile' and dt < ?", time.Now().Add(-profileExpiry).UTC().Format(dbtimeformat))
+	doordie(db, "delete from notifications where unseen = 0 and dt < ?", time.Now().Add(-30*24*time.Hour).UTC().Format(dbtimeformat))
+	doordie(db, "delete from oauthgrants where authid = 0 and dt < ?", time.Now().Add(-24*time.Hour).UTC().Format(dbtimeformat))
+	doordie(db, "delete from oauthgrants where authid > 0 and authid not in (select authid from auth)")
+	keep := keptfiles(db)
+	var orphans []int64
+	rows, err := db.Query("select fileid, xid from filemeta where fileid not in (select fileid from donks)")
+	if err != nil {
+		elog.Fatal(err)
+	}
+	for rows.Next() {
+		var fileid int64
+		var xid string
+		err = rows.Scan(&fileid, &xid)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		if !keep[xid] {
+			orphans = append(orphans, fileid)
+		}
 	}
+	rows.Close()
+	for _, fileid := range orphans {
+		doordie(db, "delete from filemeta where fileid = ?", fileid)
+	}
+	doordie(db, "delete from deliveries where xid not in (select xid from honks)")
+	doordie(db, "delete from filecache where xid not in (select xid from filemeta)")
+	doordie(db, "delete from zonkers where expiry <> '' and expiry < ?", time.Now().UTC().Format(dbtimeformat))
 
 	filexids := make(map[string]bool)
 	blobdb := openblobdb()
-	rows, err := blobdb.Query("select xid from filedata")
+	rows, err = blobdb.Query("select xid from filedata")
 	if err != nil {
 		elog.Fatal(err)
 	}
@@ -1075,40 +1210,67 @@ func cleanupdb(arg string) {
 		delete(filexids, xid)
 	}
 	rows.Close()
-	tx, err := blobdb.Begin()
-	if err != nil {
-		elog.Fatal(err)
-	}
 	for xid, _ := range filexids {
-		_, err = tx.Exec("delete from filedata where xid = ?", xid)
+		err = zapblob(xid)
 		if err != nil {
 			elog.Fatal(err)
 		}
 	}
-	err = tx.Commit()
+	rows, err = blobdb.Query("select distinct xid from filevariants where xid not in (select xid from filedata)")
 	if err != nil {
 		elog.Fatal(err)
 	}
+	var varxids []string
+	for rows.Next() {
+		var xid string
+		err = rows.Scan(&xid)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		varxids = append(varxids, xid)
+	}
+	rows.Close()
+	for _, xid := range varxids {
+		err = zapvariants(xid)
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
 }
 
 var stmtHonkers, stmtDubbers, stmtNamedDubbers, stmtSaveHonker, stmtUpdateFlavor, stmtUpdateHonker *sql.Stmt
 var stmtDeleteHonker *sql.Stmt
 var stmtAnyXonk, stmtOneXonk, stmtPublicHonks, stmtUserHonks, stmtHonksByCombo, stmtHonksByConvoy *sql.Stmt
 var stmtHonksByOntology, stmtHonksForUser, stmtHonksForMe, stmtSaveDub, stmtHonksByXonker *sql.Stmt
-var stmtHonksFromLongAgo *sql.Stmt
+var stmtHonksFromLongAgo, stmtIndexHonk, stmtUnindexHonk *sql.Stmt
 var stmtHonksByHonker, stmtSaveHonk, stmtUserByName, stmtUserByNumber *sql.Stmt
 var stmtEventHonks, stmtOneBonk, stmtFindZonk, stmtFindXonk, stmtSaveDonk *sql.Stmt
 var stmtFindFile, stmtGetFileData, stmtSaveFileData, stmtSaveFile *sql.Stmt
-var stmtCheckFileData *sql.Stmt
+var stmtCheckFileData, stmtFileDataHash, stmtCountFileHash, stmtPutBlobContent, stmtGetBlobContent *sql.Stmt
 var stmtAddDoover, stmtGetDoovers, stmtLoadDoover, stmtZapDoover, stmtOneHonker *sql.Stmt
 var stmtUntagged, stmtDeleteHonk, stmtDeleteDonks, stmtDeleteOnts, stmtSaveZonker *sql.Stmt
 var stmtGetZonkers, stmtRecentHonkers, stmtGetXonker, stmtSaveXonker, stmtDeleteXonker, stmtDeleteOldXonkers *sql.Stmt
 var stmtAllOnts, stmtSaveOnt, stmtUpdateFlags, stmtClearFlags *sql.Stmt
-var stmtHonksForUserFirstClass *sql.Stmt
+var stmtHonksForUserFirstClass, stmtHonksForUserBetween *sql.Stmt
 var stmtSaveMeta, stmtDeleteAllMeta, stmtDeleteOneMeta, stmtDeleteSomeMeta, stmtUpdateHonk *sql.Stmt
 var stmtHonksISaved, stmtGetFilters, stmtSaveFilter, stmtDeleteFilter *sql.Stmt
+var stmtGetSearches, stmtSaveSearch, stmtUpdateSearch, stmtDeleteSearch *sql.Stmt
+var stmtScheduledHonks, stmtFirstInConvoy, stmtPublishHonk, stmtExpiredHonks, stmtNextExpiry, stmtGetOneMeta *sql.Stmt
+var stmtGetRevisions, stmtTrimRevisions, stmtDeleteZonker, stmtZonkerExpiry, stmtExpireZonkers, stmtNextZonkerExpiry *sql.Stmt
+var stmtGetDrafts, stmtGetDraft, stmtSaveDraft, stmtUpdateDraft, stmtDeleteDraft *sql.Stmt
+var stmtSaveNotification, stmtGetNotifications, stmtSeeNotifications, stmtFindNotification, stmtTookPart *sql.Stmt
+var stmtGetNotificationsBetween *sql.Stmt
+var stmtOneHonkByID *sql.Stmt
+var stmtGetOAuthApp, stmtSaveOAuthApp, stmtSaveGrant, stmtFindGrant, stmtUseGrant, stmtGetOAuthGrants *sql.Stmt
+var stmtGrantAuth, stmtDeleteGrant, stmtFindGrantByAuth, stmtGrantScope *sql.Stmt
+var stmtSaveAuth, stmtDeleteAuth *sql.Stmt
 var stmtGetTracks *sql.Stmt
 var stmtSaveChonk, stmtLoadChonks, stmtGetChatters *sql.Stmt
+var stmtSaveDelivery, stmtUpdateDelivery, stmtGetDeliveries, stmtOneDelivery, stmtCancelDelivery *sql.Stmt
+var stmtSaveFileCache, stmtTouchFileCache, stmtFileCacheSize, stmtOldFileCache, stmtZapFileCache *sql.Stmt
+var stmtZapFileData, stmtUploadedFile, stmtRemoteFile, stmtUpdateFileDesc *sql.Stmt
+var stmtFileShared, stmtCopyFile *sql.Stmt
+var stmtGetVariant, stmtSaveVariant, stmtVariantHashes, stmtZapVariants, stmtPutVariantContent *sql.Stmt
 
 func preparetodie(db *sql.DB, s string) *sql.Stmt {
 	stmt, err := db.Prepare(s)
@@ -1131,8 +1293,9 @@ func prepareStatements(db *sql.DB) {
 	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, noise, precis, format, convoy, whofore, flags from honks join users on honks.userid = users.userid "
 	limit := " order by honks.honkid desc limit 250"
 	smalllimit := " order by honks.honkid desc limit ?"
-	butnotthose := " and convoy not in (select name from zonkers where userid = ? and wherefore = 'zonvoy' order by zonkerid desc limit 100)"
+	butnotthose := " and convoy not in (select name from zonkers where userid = ? and wherefore = 'zonvoy')"
 	stmtOneXonk = preparetodie(db, selecthonks+"where honks.userid = ? and xid = ?")
+	stmtOneHonkByID = preparetodie(db, selecthonks+"where honks.userid = ? and honks.honkid = ?")
 	stmtAnyXonk = preparetodie(db, selecthonks+"where xid = ? order by honks.honkid asc")
 	stmtOneBonk = preparetodie(db, selecthonks+"where honks.userid = ? and xid = ? and what = 'bonk' and whofore = 2")
 	stmtPublicHonks = preparetodie(db, selecthonks+"where whofore = 2 and dt > ?"+smalllimit)
@@ -1140,6 +1303,7 @@ func prepareStatements(db *sql.DB) {
 	stmtUserHonks = preparetodie(db, selecthonks+"where honks.honkid > ? and (whofore = 2 or whofore = ?) and username = ? and dt > ?"+smalllimit)
 	myhonkers := " and honker in (select xid from honkers where userid = ? and (flavor = 'sub' or flavor = 'peep' or flavor = 'presub') and combos not like '% - %')"
 	stmtHonksForUser = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ?"+myhonkers+butnotthose+limit)
+	stmtHonksForUserBetween = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.honkid < ? and honks.userid = ?"+myhonkers+butnotthose+limit)
 	stmtHonksForUserFirstClass = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and (what <> 'tonk')"+myhonkers+butnotthose+limit)
 	stmtHonksForMe = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and whofore = 1"+butnotthose+limit)
 	stmtHonksFromLongAgo = preparetodie(db, selecthonks+"where honks.honkid > ? and honks.userid = ? and dt > ? and dt < ? and whofore = 2"+butnotthose+limit)
@@ -1149,25 +1313,55 @@ func prepareStatements(db *sql.DB) {
 	stmtHonksByCombo = preparetodie(db, selecthonks+" where honks.honkid > ? and honks.userid = ? and honks.honker in (select xid from honkers where honkers.userid = ? and honkers.combos like ?) "+butnotthose+" union "+selecthonks+"join onts on honks.honkid = onts.honkid where honks.honkid > ? and honks.userid = ? and onts.ontology in (select xid from honkers where combos like ?)"+butnotthose+limit)
 	stmtHonksByConvoy = preparetodie(db, selecthonks+"where honks.honkid > ? and (honks.userid = ? or (? = -1 and whofore = 2)) and convoy = ?"+limit)
 	stmtHonksByOntology = preparetodie(db, selecthonks+"join onts on honks.honkid = onts.honkid where honks.honkid > ? and onts.ontology = ? and (honks.userid = ? or (? = -1 and honks.whofore = 2))"+limit)
+	stmtScheduledHonks = preparetodie(db, selecthonks+"where whofore = 4 and (honks.userid = ? or ? = -1) order by dt asc")
+	stmtFirstInConvoy = preparetodie(db, selecthonks+"where honks.userid = ? and convoy = ? order by honks.honkid asc limit 1")
+	stmtExpiredHonks = preparetodie(db, selecthonks+"where (whofore = 2 or whofore = 3) and honks.honkid in (select honkid from honkmeta where genus = 'expires' and json < ?)")
 
 	stmtSaveMeta = preparetodie(db, "insert into honkmeta (honkid, genus, json) values (?, ?, ?)")
 	stmtDeleteAllMeta = preparetodie(db, "delete from honkmeta where honkid = ?")
 	stmtDeleteSomeMeta = preparetodie(db, "delete from honkmeta where honkid = ? and genus not in ('oldrev')")
 	stmtDeleteOneMeta = preparetodie(db, "delete from honkmeta where honkid = ? and genus = ?")
+	stmtGetOneMeta = preparetodie(db, "select json from honkmeta where honkid = ? and genus = ?")
+	stmtTrimRevisions = preparetodie(db, "delete from honkmeta where honkid = ? and genus = 'oldrev' and rowid not in (select rowid from honkmeta where honkid = ? and genus = 'oldrev' order by rowid desc limit ?)")
+	stmtGetRevisions = preparetodie(db, "select json from honkmeta where honkid = ? and genus = 'oldrev' order by rowid asc")
+	stmtNextExpiry = preparetodie(db, "select min(honkmeta.json) from honkmeta join honks on honkmeta.honkid = honks.honkid where genus = 'expires' and (whofore = 2 or whofore = 3)")
 	stmtSaveHonk = preparetodie(db, "insert into honks (userid, what, honker, xid, rid, dt, url, audience, noise, convoy, whofore, format, precis, oonker, flags) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	stmtDeleteHonk = preparetodie(db, "delete from honks where honkid = ?")
+	stmtIndexHonk = preparetodie(db, "insert into honksearch (rowid, noise, precis, handle, descs) values (?, ?, ?, ?, (select group_concat(description, ' ') from donks join filemeta on donks.fileid = filemeta.fileid where donks.honkid = ?))")
+	stmtUnindexHonk = preparetodie(db, "delete from honksearch where rowid = ?")
 	stmtUpdateHonk = preparetodie(db, "update honks set precis = ?, noise = ?, format = ?, whofore = ?, dt = ? where honkid = ?")
+	stmtPublishHonk = preparetodie(db, "update honks set whofore = ?, dt = ? where honkid = ? and whofore = 4")
 	stmtSaveOnt = preparetodie(db, "insert into onts (ontology, honkid) values (?, ?)")
 	stmtDeleteOnts = preparetodie(db, "delete from onts where honkid = ?")
 	stmtSaveDonk = preparetodie(db, "insert into donks (honkid, chonkid, fileid) values (?, ?, ?)")
 	stmtDeleteDonks = preparetodie(db, "delete from donks where honkid = ?")
-	stmtSaveFile = preparetodie(db, "insert into filemeta (xid, name, description, url, media, local) values (?, ?, ?, ?, ?, ?)")
+	stmtSaveFile = preparetodie(db, "insert into filemeta (xid, name, description, url, media, local, meta) values (?, ?, ?, ?, ?, ?, ?)")
 	blobdb := openblobdb()
 	stmtSaveFileData = preparetodie(blobdb, "insert into filedata (xid, media, hash, content) values (?, ?, ?, ?)")
 	stmtCheckFileData = preparetodie(blobdb, "select xid from filedata where hash = ?")
-	stmtGetFileData = preparetodie(blobdb, "select media, content from filedata where xid = ?")
+	stmtGetFileData = preparetodie(blobdb, "select media, hash, content from filedata where xid = ?")
+	stmtFileDataHash = preparetodie(blobdb, "select hash from filedata where xid = ?")
+	stmtCountFileHash = preparetodie(blobdb, "select (select count(*) from filedata where hash = ?) + (select count(*) from filevariants where hash = ?)")
+	stmtPutBlobContent = preparetodie(blobdb, "update filedata set content = ? where hash = ?")
+	stmtPutVariantContent = preparetodie(blobdb, "update filevariants set content = ? where hash = ?")
+	stmtGetBlobContent = preparetodie(blobdb, "select content from filedata where hash = ? and content is not null union all select content from filevariants where hash = ? and content is not null limit 1")
+	stmtZapFileData = preparetodie(blobdb, "delete from filedata where xid = ?")
+	stmtGetVariant = preparetodie(blobdb, "select media, hash, content from filevariants where xid = ? and size = ?")
+	stmtSaveVariant = preparetodie(blobdb, "insert into filevariants (xid, size, media, hash, content) values (?, ?, ?, ?, ?)")
+	stmtVariantHashes = preparetodie(blobdb, "select hash from filevariants where xid = ? and hash is not null")
+	stmtZapVariants = preparetodie(blobdb, "delete from filevariants where xid = ?")
+	stmtSaveFileCache = preparetodie(db, "insert into filecache (xid, size, dt) values (?, ?, ?)")
+	stmtTouchFileCache = preparetodie(db, "update filecache set dt = ? where xid = ?")
+	stmtFileCacheSize = preparetodie(db, "select coalesce(sum(size), 0) from filecache")
+	stmtOldFileCache = preparetodie(db, "select xid, size from filecache where dt < ? order by dt asc")
+	stmtZapFileCache = preparetodie(db, "delete from filecache where xid = ?")
+	stmtUploadedFile = preparetodie(db, "select count(*) from filemeta where xid = ? and url like ?")
+	stmtRemoteFile = preparetodie(db, "select url, media from filemeta where xid = ? and local = 1 and url not like ? limit 1")
 	stmtFindXonk = preparetodie(db, "select honkid from honks where userid = ? and xid = ?")
-	stmtFindFile = preparetodie(db, "select fileid, xid from filemeta where url = ? and local = 1")
+	stmtFindFile = preparetodie(db, "select fileid, xid, description from filemeta where url = ? and local = 1")
+	stmtUpdateFileDesc = preparetodie(db, "update filemeta set description = ? where fileid = ?")
+	stmtFileShared = preparetodie(db, "select count(*) from donks where fileid = ? and honkid <> ?")
+	stmtCopyFile = preparetodie(db, "insert into filemeta (xid, name, description, url, media, local, meta) select xid, name, ?, url, media, local, meta from filemeta where fileid = ?")
 	stmtUserByName = preparetodie(db, "select userid, username, displayname, about, pubkey, seckey, options from users where username = ? and userid > 0")
 	stmtUserByNumber = preparetodie(db, "select userid, username, displayname, about, pubkey, seckey, options from users where userid = ?")
 	stmtSaveDub = preparetodie(db, "insert into honkers (userid, name, xid, flavor, combos, owner, meta, folxid) values (?, ?, ?, ?, '', '', '', ?)")
@@ -1175,10 +1369,19 @@ func prepareStatements(db *sql.DB) {
 	stmtGetDoovers = preparetodie(db, "select dooverid, dt from doovers")
 	stmtLoadDoover = preparetodie(db, "select tries, userid, rcpt, msg from doovers where dooverid = ?")
 	stmtZapDoover = preparetodie(db, "delete from doovers where dooverid = ?")
+	stmtSaveDelivery = preparetodie(db, "insert into deliveries (userid, xid, rcpt, inbox, status, tries, dt, lasterr, dooverid, msg) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmtUpdateDelivery = preparetodie(db, "update deliveries set inbox = ?, status = ?, tries = ?, dt = ?, lasterr = ?, dooverid = ?, msg = ? where userid = ? and xid = ? and rcpt = ?")
+	stmtGetDeliveries = preparetodie(db, "select deliveryid, xid, rcpt, inbox, status, tries, dt, lasterr, dooverid from deliveries where userid = ? and xid = ? order by rcpt")
+	stmtOneDelivery = preparetodie(db, "select deliveryid, xid, rcpt, inbox, status, tries, dt, lasterr, dooverid, msg from deliveries where deliveryid = ? and userid = ?")
+	stmtCancelDelivery = preparetodie(db, "update deliveries set lasterr = 'canceled', dooverid = 0 where deliveryid = ?")
 	stmtUntagged = preparetodie(db, "select xid, rid, flags from (select honkid, xid, rid, flags from honks where userid = ? order by honkid desc limit 10000) order by honkid asc")
 	stmtFindZonk = preparetodie(db, "select zonkerid from zonkers where userid = ? and name = ? and wherefore = 'zonk'")
-	stmtGetZonkers = preparetodie(db, "select zonkerid, name, wherefore from zonkers where userid = ? and wherefore <> 'zonk'")
-	stmtSaveZonker = preparetodie(db, "insert into zonkers (userid, name, wherefore) values (?, ?, ?)")
+	stmtGetZonkers = preparetodie(db, "select zonkerid, name, wherefore, expiry from zonkers where userid = ? order by zonkerid desc")
+	stmtSaveZonker = preparetodie(db, "insert into zonkers (userid, name, wherefore, expiry) values (?, ?, ?, ?)")
+	stmtDeleteZonker = preparetodie(db, "delete from zonkers where userid = ? and zonkerid = ?")
+	stmtZonkerExpiry = preparetodie(db, "update zonkers set expiry = ? where userid = ? and zonkerid = ?")
+	stmtExpireZonkers = preparetodie(db, "delete from zonkers where expiry <> '' and expiry < ?")
+	stmtNextZonkerExpiry = preparetodie(db, "select min(expiry) from zonkers where expiry <> ''")
 	stmtGetXonker = preparetodie(db, "select info from xonkers where name = ? and flavor = ?")
 	stmtSaveXonker = preparetodie(db, "insert into xonkers (name, info, flavor, dt) values (?, ?, ?, ?)")
 	stmtDeleteXonker = preparetodie(db, "delete from xonkers where name = ? and flavor = ? and dt < ?")
@@ -1190,6 +1393,33 @@ func prepareStatements(db *sql.DB) {
 	stmtGetFilters = preparetodie(db, "select hfcsid, json from hfcs where userid = ?")
 	stmtSaveFilter = preparetodie(db, "insert into hfcs (userid, json) values (?, ?)")
 	stmtDeleteFilter = preparetodie(db, "delete from hfcs where userid = ? and hfcsid = ?")
+	stmtGetSearches = preparetodie(db, "select searchid, json from searches where userid = ?")
+	stmtSaveSearch = preparetodie(db, "insert into searches (userid, json) values (?, ?)")
+	stmtUpdateSearch = preparetodie(db, "update searches set json = ? where searchid = ? and userid = ?")
+	stmtDeleteSearch = preparetodie(db, "delete from searches where userid = ? and searchid = ?")
+	stmtGetDrafts = preparetodie(db, "select draftid, json from drafts where userid = ? order by dt desc")
+	stmtGetDraft = preparetodie(db, "select draftid, json from drafts where userid = ? and draftid = ?")
+	stmtSaveDraft = preparetodie(db, "insert into drafts (userid, dt, json) values (?, ?, ?)")
+	stmtUpdateDraft = preparetodie(db, "update drafts set dt = ?, json = ? where draftid = ? and userid = ?")
+	stmtDeleteDraft = preparetodie(db, "delete from drafts where userid = ? and draftid = ?")
+	stmtSaveNotification = preparetodie(db, "insert into notifications (userid, what, who, xid, aux, dt, unseen) values (?, ?, ?, ?, ?, ?, 1)")
+	stmtGetNotifications = preparetodie(db, "select notifyid, what, who, xid, aux, dt, unseen from notifications where userid = ? and notifyid > ? order by notifyid desc limit 250")
+	stmtGetNotificationsBetween = preparetodie(db, "select notifyid, what, who, xid, aux, dt, unseen from notifications where userid = ? and notifyid > ? and notifyid < ? order by notifyid desc limit 250")
+	stmtSeeNotifications = preparetodie(db, "update notifications set unseen = 0 where userid = ? and unseen = 1")
+	stmtFindNotification = preparetodie(db, "select notifyid from notifications where userid = ? and what = ? and who = ? and xid = ?")
+	stmtTookPart = preparetodie(db, "select honkid from honks where userid = ? and convoy = ? and whofore in (2, 3) limit 1")
+	stmtGetOAuthApp = preparetodie(db, "select appid, clientid, secret, name, redirect, website from oauthapps where clientid = ?")
+	stmtSaveOAuthApp = preparetodie(db, "insert into oauthapps (clientid, secret, name, redirect, website, dt) values (?, ?, ?, ?, ?, ?)")
+	stmtSaveGrant = preparetodie(db, "insert into oauthgrants (appid, userid, code, scope, dt, authid, redirect) values (?, ?, ?, ?, ?, 0, ?)")
+	stmtFindGrant = preparetodie(db, "select grantid, userid, scope, dt, redirect from oauthgrants where appid = ? and code = ? and authid = 0")
+	stmtUseGrant = preparetodie(db, "update oauthgrants set authid = ?, code = '' where grantid = ? and authid = 0")
+	stmtGetOAuthGrants = preparetodie(db, "select grantid, name, scope, oauthgrants.dt from oauthgrants join oauthapps on oauthgrants.appid = oauthapps.appid where userid = ? and authid > 0 order by grantid desc")
+	stmtGrantScope = preparetodie(db, "select scope from oauthgrants join auth on oauthgrants.authid = auth.authid where hash = ?")
+	stmtGrantAuth = preparetodie(db, "select authid from oauthgrants where grantid = ? and userid = ?")
+	stmtDeleteGrant = preparetodie(db, "delete from oauthgrants where grantid = ? and userid = ?")
+	stmtFindGrantByAuth = preparetodie(db, "select grantid, oauthgrants.userid from oauthgrants join auth on oauthgrants.authid = auth.authid where appid = ? and hash = ?")
+	stmtSaveAuth = preparetodie(db, "insert into auth (userid, hash, expiry) values (?, ?, ?)")
+	stmtDeleteAuth = preparetodie(db, "delete from auth where authid = ? and userid = ?")
 	stmtGetTracks = preparetodie(db, "select fetches from tracks where xid = ?")
 	stmtSaveChonk = preparetodie(db, "insert into chonks (userid, xid, who, target, dt, noise, format) values (?, ?, ?, ?, ?, ?, ?)")
 	stmtLoadChonks = preparetodie(db, "select chonkid, userid, xid, who, target, dt, noise, format from chonks where userid = ? and dt > ? order by chonkid asc")
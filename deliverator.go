@@ -16,11 +16,17 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	notrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"humungus.tedunangst.com/r/webs/gate"
+	"humungus.tedunangst.com/r/webs/junk"
 )
 
 type Doover struct {
@@ -28,34 +34,82 @@ type Doover struct {
 	When time.Time
 }
 
-func sayitagain(goarounds int64, userid int64, rcpt string, msg []byte) {
-	var drift time.Duration
-	switch goarounds {
-	case 1:
-		drift = 5 * time.Minute
-	case 2:
-		drift = 1 * time.Hour
-	case 3:
-		drift = 4 * time.Hour
-	case 4:
-		drift = 12 * time.Hour
-	case 5:
-		drift = 24 * time.Hour
-	default:
+type Delivery struct {
+	ID       int64
+	XID      string
+	Rcpt     string
+	Inbox    string
+	Status   int
+	Tries    int64
+	When     time.Time
+	Err      string
+	DooverID int64
+	msg      []byte
+}
+
+// redelivery schedule, delays in minutes, jitter in percent.
+// setting a delay and multiplier replaces the schedule with an exponential one.
+var retrySchedule = []int{5, 60, 4 * 60, 12 * 60, 24 * 60}
+var retryCount int64 = 5
+var retryDelay = 0
+var retryMultiplier = 0.0
+var retryMaxDelay = 24 * 60
+var retryJitter = 10
+
+func retrydrift(goarounds int64) time.Duration {
+	var drift float64
+	if retryDelay > 0 && retryMultiplier > 0 {
+		drift = float64(retryDelay) * float64(time.Minute)
+		for i := int64(1); i < goarounds; i++ {
+			drift *= retryMultiplier
+			if drift > float64(retryMaxDelay)*float64(time.Minute) {
+				break
+			}
+		}
+	} else {
+		i := goarounds - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= int64(len(retrySchedule)) {
+			i = int64(len(retrySchedule)) - 1
+		}
+		drift = float64(retrySchedule[i]) * float64(time.Minute)
+	}
+	if maxdrift := float64(retryMaxDelay) * float64(time.Minute); drift > maxdrift {
+		drift = maxdrift
+	}
+	if drift < float64(time.Minute) {
+		drift = float64(time.Minute)
+	}
+	return time.Duration(drift)
+}
+
+func sayitagain(goarounds int64, userid int64, rcpt string, msg []byte) int64 {
+	if goarounds > retryCount {
 		ilog.Printf("he's dead jim: %s", rcpt)
 		clearoutbound(rcpt)
-		return
+		return 0
+	}
+	drift := retrydrift(goarounds)
+	if jitter := int64(drift) * int64(retryJitter) / 100; jitter > 0 {
+		drift += time.Duration(notrand.Int63n(jitter))
 	}
-	drift += time.Duration(notrand.Int63n(int64(drift / 10)))
 	when := time.Now().Add(drift)
-	_, err := stmtAddDoover.Exec(when.UTC().Format(dbtimeformat), goarounds, userid, rcpt, msg)
+	if later := backingoff(rcpt); when.Before(later) {
+		when = later
+	}
+	res, err := stmtAddDoover.Exec(when.UTC().Format(dbtimeformat), goarounds, userid, rcpt, msg)
 	if err != nil {
 		elog.Printf("error saving doover: %s", err)
+		return 0
 	}
+	dooverid, _ := res.LastInsertId()
 	select {
 	case pokechan <- 0:
 	default:
 	}
+	return dooverid
 }
 
 func clearoutbound(rcpt string) {
@@ -69,18 +123,189 @@ func clearoutbound(rcpt string) {
 	db.Exec("delete from doovers where rcpt like ?", xid)
 }
 
+// total posts in flight, taken only once a host is ready for us
 var garage = gate.NewLimiter(40)
 
-func deliverate(goarounds int64, userid int64, rcpt string, msg []byte, prio bool) {
-	garage.Start()
-	defer garage.Finish()
+// requests in flight and requests per minute for any one host
+var hostConcurrency = 4
+var hostRate = 120
+var hostBurst = 20
+
+type hostgate struct {
+	sync.Mutex
+	lanes   *gate.Limiter
+	tokens  float64
+	refill  time.Time
+	backoff time.Time
+	holders int
+	lastuse time.Time
+}
+
+var hostgates = make(map[string]*hostgate)
+var hostgateslock sync.Mutex
+var lastgatesweep time.Time
+
+// forget about hosts nobody has talked to in a while
+const hostGateIdle = 10 * time.Minute
+
+// hold means the caller will release it when done
+func gethostgate(host string, hold bool) *hostgate {
+	hostgateslock.Lock()
+	defer hostgateslock.Unlock()
+	now := time.Now()
+	if now.Sub(lastgatesweep) > hostGateIdle {
+		sweephostgates(now)
+		lastgatesweep = now
+	}
+	hg := hostgates[host]
+	if hg == nil {
+		lanes := hostConcurrency
+		if lanes < 1 {
+			lanes = 1
+		}
+		hg = &hostgate{
+			lanes:  gate.NewLimiter(lanes),
+			tokens: float64(hostBurst),
+			refill: now,
+		}
+		hostgates[host] = hg
+	}
+	hg.lastuse = now
+	if hold {
+		hg.holders++
+	}
+	return hg
+}
+
+func (hg *hostgate) release() {
+	hostgateslock.Lock()
+	hg.holders--
+	hg.lastuse = time.Now()
+	hostgateslock.Unlock()
+}
+
+// called with hostgateslock held
+func sweephostgates(now time.Time) {
+	for host, hg := range hostgates {
+		if hg.holders > 0 || now.Sub(hg.lastuse) < hostGateIdle {
+			continue
+		}
+		hg.Lock()
+		backoff := hg.backoff
+		hg.Unlock()
+		if now.Before(backoff) {
+			continue
+		}
+		delete(hostgates, host)
+	}
+}
+
+// take a token, or say how long until one is available
+func (hg *hostgate) taketoken() time.Duration {
+	if hostRate <= 0 {
+		return 0
+	}
+	hg.Lock()
+	defer hg.Unlock()
+	now := time.Now()
+	burst := float64(hostBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	hg.tokens += now.Sub(hg.refill).Minutes() * float64(hostRate)
+	if hg.tokens > burst {
+		hg.tokens = burst
+	}
+	hg.refill = now
+	if hg.tokens >= 1 {
+		hg.tokens -= 1
+		return 0
+	}
+	return time.Duration((1 - hg.tokens) / float64(hostRate) * float64(time.Minute))
+}
+
+// wait our turn to talk to the host for url.
+// the returned func must be called when done.
+func hostthrottle(url string) (func(), error) {
+	host := originate(url)
+	if host == "" {
+		return func() {}, nil
+	}
+	if later := backingoff(url); time.Now().Before(later) {
+		return nil, fmt.Errorf("backing off %s until %s", host, later.Format(time.RFC3339))
+	}
+	hg := gethostgate(host, true)
+	hg.lanes.Start()
+	for {
+		wait := hg.taketoken()
+		if wait == 0 {
+			break
+		}
+		time.Sleep(wait)
+	}
+	return func() {
+		hg.lanes.Finish()
+		hg.release()
+	}, nil
+}
+
+// the host asked us to go away for a bit
+func pleasewait(url string, resp *http.Response) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+	case http.StatusServiceUnavailable:
+	default:
+		return
+	}
+	host := originate(url)
+	if host == "" {
+		return
+	}
+	var later time.Time
+	ra := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(ra); err == nil {
+		later = time.Now().Add(time.Duration(secs) * time.Second)
+	} else if t, err := http.ParseTime(ra); err == nil {
+		later = t
+	} else {
+		later = time.Now().Add(1 * time.Minute)
+	}
+	if toolong := time.Now().Add(24 * time.Hour); later.After(toolong) {
+		later = toolong
+	}
+	ilog.Printf("backing off %s until %s", host, later.Format(time.RFC3339))
+	hg := gethostgate(host, false)
+	hg.Lock()
+	if later.After(hg.backoff) {
+		hg.backoff = later
+	}
+	hg.Unlock()
+}
+
+func backingoff(url string) time.Time {
+	host := originate(url)
+	if host == "" {
+		return time.Time{}
+	}
+	hostgateslock.Lock()
+	hg := hostgates[host]
+	hostgateslock.Unlock()
+	if hg == nil {
+		return time.Time{}
+	}
+	hg.Lock()
+	defer hg.Unlock()
+	return hg.backoff
+}
 
+func deliverate(goarounds int64, userid int64, rcpt string, msg []byte, prio bool) {
 	var ki *KeyInfo
 	ok := ziggies.Get(userid, &ki)
 	if !ok {
 		elog.Printf("lost key for delivery")
 		return
 	}
+	xid := whatwasdelivered(msg)
 	var inbox string
 	// already did the box indirection
 	if rcpt[0] == '%' {
@@ -90,19 +315,153 @@ func deliverate(goarounds int64, userid int64, rcpt string, msg []byte, prio boo
 		ok := boxofboxes.Get(rcpt, &box)
 		if !ok {
 			ilog.Printf("failed getting inbox for %s", rcpt)
-			sayitagain(goarounds+1, userid, rcpt, msg)
+			dooverid := sayitagain(goarounds+1, userid, rcpt, msg)
+			logdelivery(userid, xid, rcpt, "", goarounds+1, 0, "no inbox", dooverid, msg)
 			return
 		}
 		inbox = box.In
 	}
-	err := PostMsg(ki.keyname, ki.seckey, inbox, msg)
+	status, err := PostMsg(ki.keyname, ki.seckey, inbox, msg)
 	if err != nil {
 		ilog.Printf("failed to post json to %s: %s", inbox, err)
-		if prio {
-			sayitagain(goarounds+1, userid, rcpt, msg)
+		var dooverid int64
+		if prio && worthretrying(err) {
+			dooverid = sayitagain(goarounds+1, userid, rcpt, msg)
 		}
+		logdelivery(userid, xid, rcpt, inbox, goarounds+1, status, err.Error(), dooverid, msg)
+		return
+	}
+	logdelivery(userid, xid, rcpt, inbox, goarounds+1, status, "", 0, msg)
+}
+
+// the xid of the honk this activity is about, so creates, deletes,
+// bonks and the rest all show up together, or else the activity itself
+func whatwasdelivered(msg []byte) string {
+	j, err := junk.FromBytes(msg)
+	if err != nil {
+		return ""
+	}
+	if xid, ok := j.GetString("object", "id"); ok {
+		return xid
+	}
+	if xid, ok := j.GetString("object"); ok {
+		return xid
+	}
+	xid, _ := j.GetString("id")
+	return xid
+}
+
+func poststatus(err error) int {
+	var perr *PostError
+	if errors.As(err, &perr) {
+		return perr.StatusCode
+	}
+	return 0
+}
+
+// client errors won't get better by trying again
+func worthretrying(err error) bool {
+	code := poststatus(err)
+	switch {
+	case code == http.StatusRequestTimeout:
+		return true
+	case code == http.StatusTooManyRequests:
+		return true
+	case code >= 400 && code < 500:
+		return false
+	}
+	return true
+}
+
+// the message is kept so a retry sends the same thing again
+func logdelivery(userid int64, xid string, rcpt string, inbox string, tries int64, status int, lasterr string, dooverid int64, msg []byte) {
+	if xid == "" {
 		return
 	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	res, err := stmtUpdateDelivery.Exec(inbox, status, tries, dt, lasterr, dooverid, msg, userid, xid, rcpt)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			_, err = stmtSaveDelivery.Exec(userid, xid, rcpt, inbox, status, tries, dt, lasterr, dooverid, msg)
+		}
+	}
+	if err != nil {
+		elog.Printf("error saving delivery: %s", err)
+	}
+}
+
+func getdeliveries(userid int64, xid string) []*Delivery {
+	rows, err := stmtGetDeliveries.Query(userid, xid)
+	if err != nil {
+		elog.Printf("error querying deliveries: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var deliveries []*Delivery
+	for rows.Next() {
+		d := new(Delivery)
+		var dt string
+		err := rows.Scan(&d.ID, &d.XID, &d.Rcpt, &d.Inbox, &d.Status, &d.Tries, &dt, &d.Err, &d.DooverID)
+		if err != nil {
+			elog.Printf("error scanning delivery: %s", err)
+			continue
+		}
+		d.When, _ = time.Parse(dbtimeformat, dt)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+func getdelivery(userid int64, deliveryid int64) *Delivery {
+	row := stmtOneDelivery.QueryRow(deliveryid, userid)
+	d := new(Delivery)
+	var dt string
+	err := row.Scan(&d.ID, &d.XID, &d.Rcpt, &d.Inbox, &d.Status, &d.Tries, &dt, &d.Err, &d.DooverID, &d.msg)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			elog.Printf("error scanning delivery: %s", err)
+		}
+		return nil
+	}
+	d.When, _ = time.Parse(dbtimeformat, dt)
+	return d
+}
+
+// skip the wait and try again right now
+func redeliver(user *WhatAbout, d *Delivery) error {
+	msg := d.msg
+	if d.DooverID != 0 {
+		var goarounds, userid int64
+		var rcpt string
+		var doover []byte
+		row := stmtLoadDoover.QueryRow(d.DooverID)
+		err := row.Scan(&goarounds, &userid, &rcpt, &doover)
+		if err == nil {
+			stmtZapDoover.Exec(d.DooverID)
+			msg = doover
+		}
+	}
+	// whatever it was, we don't know anymore
+	if len(msg) == 0 {
+		return errors.New("nothing to send again")
+	}
+	ilog.Printf("manually redeliverating %s", d.Rcpt)
+	go deliverate(d.Tries, user.ID, d.Rcpt, msg, true)
+	return nil
+}
+
+// give up on any pending retry
+func canceldelivery(d *Delivery) {
+	if d.DooverID != 0 {
+		_, err := stmtZapDoover.Exec(d.DooverID)
+		if err != nil {
+			elog.Printf("error deleting doover: %s", err)
+		}
+	}
+	_, err := stmtCancelDelivery.Exec(d.ID)
+	if err != nil {
+		elog.Printf("error canceling delivery: %s", err)
+	}
 }
 
 var pokechan = make(chan int, 1)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDrift(t *testing.T) {
+	want := []time.Duration{5 * time.Minute, 1 * time.Hour, 4 * time.Hour, 12 * time.Hour, 24 * time.Hour, 24 * time.Hour}
+	for i, w := range want {
+		if got := retrydrift(int64(i + 1)); got != w {
+			t.Errorf("retry %d: got %s want %s", i+1, got, w)
+		}
+	}
+
+	savedDelay, savedMult, savedMax := retryDelay, retryMultiplier, retryMaxDelay
+	defer func() {
+		retryDelay, retryMultiplier, retryMaxDelay = savedDelay, savedMult, savedMax
+	}()
+	retryDelay = 2
+	retryMultiplier = 3
+	retryMaxDelay = 60
+	want = []time.Duration{2 * time.Minute, 6 * time.Minute, 18 * time.Minute, 54 * time.Minute, 60 * time.Minute}
+	for i, w := range want {
+		if got := retrydrift(int64(i + 1)); got != w {
+			t.Errorf("exponential retry %d: got %s want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestWorthRetrying(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{&PostError{StatusCode: 500}, true},
+		{&PostError{StatusCode: 502}, true},
+		{&PostError{StatusCode: 408}, true},
+		{&PostError{StatusCode: 429}, true},
+		{&PostError{StatusCode: 400}, false},
+		{&PostError{StatusCode: 403}, false},
+		{&PostError{StatusCode: 404}, false},
+		{&PostError{StatusCode: 410}, false},
+	}
+	for _, tt := range tests {
+		if got := worthretrying(tt.err); got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// Drafts live in their own table, not in honks, so there's no way for
+// them to wander into a timeline or out the door.
+type Draft struct {
+	ID        int64 `json:"-"`
+	Date      time.Time
+	Noise     string
+	Precis    string            `json:",omitempty"`
+	Format    string            `json:",omitempty"`
+	RID       string            `json:",omitempty"`
+	Place     *Place            `json:",omitempty"`
+	Time      *Time             `json:",omitempty"`
+	DonkXIDs  []string          `json:",omitempty"`
+	DonkDescs map[string]string `json:",omitempty"`
+}
+
+func scandraft(row RowLike) *Draft {
+	d := new(Draft)
+	var j string
+	err := row.Scan(&d.ID, &j)
+	if err == nil {
+		err = unjsonify(j, d)
+	}
+	if err != nil {
+		elog.Printf("error scanning draft: %s", err)
+		return nil
+	}
+	return d
+}
+
+func getdrafts(userid int64) []*Draft {
+	rows, err := stmtGetDrafts.Query(userid)
+	if err != nil {
+		elog.Printf("error querying drafts: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var drafts []*Draft
+	for rows.Next() {
+		d := scandraft(rows)
+		if d != nil {
+			drafts = append(drafts, d)
+		}
+	}
+	return drafts
+}
+
+func getdraft(userid int64, draftid int64) *Draft {
+	row := stmtGetDraft.QueryRow(userid, draftid)
+	return scandraft(row)
+}
+
+func savedraft(userid int64, d *Draft) error {
+	d.Date = time.Now().UTC()
+	j, err := jsonify(d)
+	if err != nil {
+		return err
+	}
+	dt := d.Date.Format(dbtimeformat)
+	if d.ID != 0 {
+		_, err = stmtUpdateDraft.Exec(dt, j, d.ID, userid)
+		return err
+	}
+	res, err := stmtSaveDraft.Exec(userid, dt, j)
+	if err == nil {
+		d.ID, _ = res.LastInsertId()
+	}
+	return err
+}
+
+func deletedraft(userid int64, draftid int64) {
+	_, err := stmtDeleteDraft.Exec(userid, draftid)
+	if err != nil {
+		elog.Printf("error deleting draft: %s", err)
+	}
+}
+
+// the saved files, with any edited descriptions
+func (d *Draft) Donks() []*Donk {
+	var donks []*Donk
+	for _, xid := range d.DonkXIDs {
+		url := fmt.Sprintf("https://%s/d/%s", serverName, xid)
+		donk := finddonk(url)
+		if donk == nil {
+			ilog.Printf("can't find file: %s", xid)
+			continue
+		}
+		if desc, ok := d.DonkDescs[xid]; ok {
+			donk.Desc = desc
+		}
+		donks = append(donks, donk)
+	}
+	return donks
+}
+
+// takes the same form as submithonk
+func submitdraft(w http.ResponseWriter, r *http.Request) *Draft {
+	userinfo := login.GetUserInfo(r)
+	d := new(Draft)
+	if draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0); draftid != 0 {
+		d = getdraft(userinfo.UserID, draftid)
+		if d == nil {
+			http.Error(w, "no such draft", http.StatusNotFound)
+			return nil
+		}
+	}
+	d.Noise = strings.Replace(r.FormValue("noise"), "\r", "", -1)
+	d.Precis = ""
+	if strings.HasPrefix(d.Noise, "DZ:") {
+		precis := d.Noise
+		if idx := strings.IndexByte(precis, '\n'); idx != -1 {
+			precis = precis[:idx]
+		}
+		d.Precis = strings.TrimSpace(precis[3:])
+	}
+	d.Format = r.FormValue("format")
+	d.RID = r.FormValue("rid")
+	d.Place = formplace(r)
+	d.Time = formtime(r)
+
+	donks, err := submitdonks(w, r)
+	if err != nil {
+		return nil
+	}
+	d.DonkXIDs = nil
+	d.DonkDescs = nil
+	for _, xid := range r.Form["donkxid"] {
+		if xid == "" {
+			continue
+		}
+		d.DonkXIDs = append(d.DonkXIDs, xid)
+		if desc, ok := r.Form["donkdesc."+xid]; ok {
+			if d.DonkDescs == nil {
+				d.DonkDescs = make(map[string]string)
+			}
+			d.DonkDescs[xid] = strings.TrimSpace(desc[0])
+		}
+	}
+	for _, donk := range donks {
+		d.DonkXIDs = append(d.DonkXIDs, donk.XID)
+	}
+
+	if d.Noise == "" && len(d.DonkXIDs) == 0 {
+		http.Error(w, "can't save a blank draft", http.StatusBadRequest)
+		return nil
+	}
+	err = savedraft(userinfo.UserID, d)
+	if err != nil {
+		elog.Printf("error saving draft: %s", err)
+		http.Error(w, "error saving draft", http.StatusInternalServerError)
+		return nil
+	}
+	return d
+}
@@ -64,6 +64,7 @@ func loadLingo() {
 func reverbolate(userid int64, honks []*Honk) {
 	var user *WhatAbout
 	somenumberedusers.Get(userid, &user)
+	var remotes []*Honk
 	for _, h := range honks {
 		h.What += "ed"
 		if h.What == "tonked" {
@@ -130,49 +131,33 @@ func reverbolate(userid int64, honks []*Honk) {
 			}
 		}
 
+		if !local || h.What == "bonked" {
+			// somebody else wrote this, let the backend look at it
+			remotes = append(remotes, h)
+			continue
+		}
+
 		zap := make(map[string]bool)
-		{
-			var htf htfilter.Filter
-			htf.Imager = replaceimgsand(zap, false)
-			htf.SpanClasses = allowedclasses
-			htf.BaseURL, _ = url.Parse(h.XID)
-			emuxifier := func(e string) string {
-				for _, d := range h.Donks {
-					if d.Name == e {
-						zap[d.XID] = true
-						if d.Local {
-							return fmt.Sprintf(`<img class="emu" title="%s" src="/d/%s">`, d.Name, d.XID)
-						}
-					}
-				}
-				if local && h.What != "bonked" {
-					var emu Emu
-					emucache.Get(e, &emu)
-					if emu.ID != "" {
-						return fmt.Sprintf(`<img class="emu" title="%s" src="%s">`, emu.Name, emu.ID)
+		emuxifier := func(e string) string {
+			for _, d := range h.Donks {
+				if d.Name == e {
+					zap[d.XID] = true
+					if d.Local {
+						return fmt.Sprintf(`<img class="emu" title="%s" src="/d/%s">`, d.Name, d.XID)
 					}
 				}
-				return e
-			}
-			htf.FilterText = func(w io.Writer, data string) {
-				data = htfilter.EscapeText(data)
-				data = re_emus.ReplaceAllStringFunc(data, emuxifier)
-				io.WriteString(w, data)
 			}
-			p, _ := htf.String(h.Precis)
-			n, _ := htf.String(h.Noise)
-			h.Precis = string(p)
-			h.Noise = string(n)
-		}
-		j := 0
-		for i := 0; i < len(h.Donks); i++ {
-			if !zap[h.Donks[i].XID] {
-				h.Donks[j] = h.Donks[i]
-				j++
+			var emu Emu
+			emucache.Get(e, &emu)
+			if emu.ID != "" {
+				return fmt.Sprintf(`<img class="emu" title="%s" src="%s">`, emu.Name, emu.ID)
 			}
+			return e
 		}
-		h.Donks = h.Donks[:j]
+		h.Precis, h.Noise = filterhonkhtml(h.XID, h.Precis, h.Noise, replaceimgsand(zap, false), emuxifier)
+		zapdonks(h, zap)
 	}
+	sanitizehonks(remotes)
 
 	unsee(honks, userid)
 
@@ -190,6 +175,32 @@ func reverbolate(userid int64, honks []*Honk) {
 	}
 }
 
+func filterhonkhtml(baseurl string, precis string, noise string, imager func(node *html.Node) string, emuxifier func(string) string) (string, string) {
+	var htf htfilter.Filter
+	htf.Imager = imager
+	htf.SpanClasses = allowedclasses
+	htf.BaseURL, _ = url.Parse(baseurl)
+	htf.FilterText = func(w io.Writer, data string) {
+		data = htfilter.EscapeText(data)
+		data = re_emus.ReplaceAllStringFunc(data, emuxifier)
+		io.WriteString(w, data)
+	}
+	p, _ := htf.String(precis)
+	n, _ := htf.String(noise)
+	return string(p), string(n)
+}
+
+func zapdonks(h *Honk, zap map[string]bool) {
+	j := 0
+	for i := 0; i < len(h.Donks); i++ {
+		if !zap[h.Donks[i].XID] {
+			h.Donks[j] = h.Donks[i]
+			j++
+		}
+	}
+	h.Donks = h.Donks[:j]
+}
+
 func replaceimgsand(zap map[string]bool, absolute bool) func(node *html.Node) string {
 	return func(node *html.Node) string {
 		src := htfilter.GetAttr(node, "src")
@@ -216,6 +227,11 @@ func translatechonk(ch *Chonk) {
 	if ch.Format == "markdown" {
 		noise = markitzero(noise)
 	}
+	if originate(ch.XID) != serverName {
+		// somebody else wrote this, let the backend look at it
+		ch.HTML = template.HTML(filterhtml(ch.XID, noise)[0])
+		return
+	}
 	var htf htfilter.Filter
 	htf.SpanClasses = allowedclasses
 	htf.BaseURL, _ = url.Parse(ch.XID)
@@ -270,26 +286,16 @@ func filterchonk(ch *Chonk) {
 
 }
 
-func inlineimgsfor(honk *Honk) func(node *html.Node) string {
-	return func(node *html.Node) string {
-		src := htfilter.GetAttr(node, "src")
-		alt := htfilter.GetAttr(node, "alt")
-		d := savedonk(src, "image", alt, "image", true)
+func imaginate(honk *Honk) {
+	for _, img := range findimages(honk) {
+		d := savedonk(img.Src, "image", img.Alt, "image", true, DonkMeta{})
 		if d != nil {
 			honk.Donks = append(honk.Donks, d)
 		}
-		dlog.Printf("inline img with src: %s", src)
-		return ""
+		dlog.Printf("inline img with src: %s", img.Src)
 	}
 }
 
-func imaginate(honk *Honk) {
-	var htf htfilter.Filter
-	htf.Imager = inlineimgsfor(honk)
-	htf.BaseURL, _ = url.Parse(honk.XID)
-	htf.String(honk.Noise)
-}
-
 func translate(honk *Honk) {
 	if honk.Format == "html" {
 		return
@@ -439,7 +445,7 @@ func memetize(honk *Honk) {
 		fd.Close()
 
 		url := fmt.Sprintf("https://%s/meme/%s", serverName, name)
-		fileid, err := savefile(name, name, url, ct, false, nil)
+		fileid, err := savefile(name, name, url, ct, false, nil, DonkMeta{})
 		if err != nil {
 			elog.Printf("error saving meme: %s", err)
 			return x
@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"humungus.tedunangst.com/r/webs/httpsig"
+	"humungus.tedunangst.com/r/webs/image"
 	"humungus.tedunangst.com/r/webs/log"
 )
 
@@ -51,16 +52,19 @@ type WhatAbout struct {
 }
 
 type UserOptions struct {
-	SkinnyCSS  bool   `json:",omitempty"`
-	OmitImages bool   `json:",omitempty"`
-	Avahex     bool   `json:",omitempty"`
-	MentionAll bool   `json:",omitempty"`
-	Avatar     string `json:",omitempty"`
-	Banner     string `json:",omitempty"`
-	MapLink    string `json:",omitempty"`
-	Reaction   string `json:",omitempty"`
-	MeCount    int64
-	ChatCount  int64
+	SkinnyCSS          bool     `json:",omitempty"`
+	OmitImages         bool     `json:",omitempty"`
+	Avahex             bool     `json:",omitempty"`
+	MentionAll         bool     `json:",omitempty"`
+	Avatar             string   `json:",omitempty"`
+	Banner             string   `json:",omitempty"`
+	MapLink            string   `json:",omitempty"`
+	Reaction           string   `json:",omitempty"`
+	Expiration         string   `json:",omitempty"`
+	MutedNotifications []string `json:",omitempty"`
+	MeCount            int64
+	ChatCount          int64
+	NoteCount          int64
 }
 
 type KeyInfo struct {
@@ -105,6 +109,10 @@ type Honk struct {
 	Badonks  []Badonk
 	Wonkles  string
 	Guesses  template.HTML
+	Snippet  template.HTML
+	Lang     string
+	Expires  time.Time
+	Edited   bool
 }
 
 type Badonk struct {
@@ -148,6 +156,7 @@ func (mention *Mention) IsPresent(noise string) bool {
 type OldRevision struct {
 	Precis string
 	Noise  string
+	Date   time.Time
 }
 
 const (
@@ -192,6 +201,16 @@ type Donk struct {
 	Media    string
 	Local    bool
 	External bool
+	Meta     DonkMeta
+	redesc   bool
+}
+
+type DonkMeta struct {
+	Width    int    `json:",omitempty"`
+	Height   int    `json:",omitempty"`
+	Blurhash string `json:",omitempty"`
+	Variants []int  `json:",omitempty"`
+	variants []*image.Image
 }
 
 type Place struct {
@@ -246,6 +265,28 @@ type HonkerMeta struct {
 	Notes string
 }
 
+type Profile struct {
+	XID       string
+	Name      string
+	Handle    string
+	Summary   string
+	Icon      string `json:",omitempty"`
+	Image     string `json:",omitempty"`
+	Avatar    string `json:",omitempty"`
+	Header    string `json:",omitempty"`
+	Fields    []ProfileField
+	Followers int64
+	Following int64
+	Created   time.Time
+	HTSummary template.HTML `json:"-"`
+}
+
+type ProfileField struct {
+	Name  string
+	Value string
+	HTML  template.HTML `json:"-"`
+}
+
 type SomeThing struct {
 	What  int
 	XID   string
@@ -333,7 +374,27 @@ func main() {
 	getconfig("fasttimeout", &fastTimeout)
 	getconfig("slowtimeout", &slowTimeout)
 	getconfig("signgets", &signGets)
+	getconfig("hostconcurrency", &hostConcurrency)
+	getconfig("hostrate", &hostRate)
+	getconfig("hostburst", &hostBurst)
+	getconfig("retrycount", &retryCount)
+	getconfig("retrydelay", &retryDelay)
+	getconfig("retrymultiplier", &retryMultiplier)
+	getconfig("retrymaxdelay", &retryMaxDelay)
+	getconfig("retryjitter", &retryJitter)
+	getconfig("mediacachesize", &mediaCacheSize)
+	getconfig("mediacachedays", &mediaCacheDays)
+	getconfig("maxvideosize", &maxVideoSize)
+	getconfig("maxaudiosize", &maxAudioSize)
+	getconfig("blobstore", &blobStoreName)
+	getconfig("blobdir", &blobDir)
+	getconfig("s3endpoint", &s3Endpoint)
+	getconfig("s3bucket", &s3Bucket)
+	getconfig("s3region", &s3Region)
+	getconfig("s3accesskey", &s3AccessKey)
+	getconfig("s3secretkey", &s3SecretKey)
 	prepareStatements(db)
+	blobs = openblobstore(blobStoreName)
 	switch cmd {
 	case "admin":
 		adminscreen()
@@ -391,6 +452,12 @@ func main() {
 		}
 		name := args[1]
 		unplugserver(name)
+	case "migrateblobs":
+		if len(args) != 3 {
+			fmt.Printf("usage: honk migrateblobs (sqlite|files|s3) (sqlite|files|s3)\n")
+			return
+		}
+		migrateblobs(args[1], args[2])
 	case "backup":
 		if len(args) < 2 {
 			fmt.Printf("usage: honk backup dirname\n")
@@ -174,8 +174,23 @@ func hooterize(noise string) string {
 			return hoot
 		}
 		ld, _ := os.Create("lasthoot.html")
-		r := io.TeeReader(resp.Body, ld)
-		return hootextractor(r, url, seen)
+		r := io.TeeReader(io.LimitReader(resp.Body, 10*1024*1024), ld)
+		data, err := io.ReadAll(r)
+		ld.Close()
+		if err != nil {
+			ilog.Printf("error reading %s: %s", url, err)
+			return hoot
+		}
+		var res HootResult
+		err = callbackend("Hooter.Extract", &HootArgs{HTML: data, URL: url, Seen: seen}, &res)
+		if err != nil {
+			elog.Printf("error extracting hoot: %s", err)
+			return hoot
+		}
+		for k, v := range res.Seen {
+			seen[k] = v
+		}
+		return res.Text
 	}
 
 	return re_hoots.ReplaceAllStringFunc(noise, hootfetcher)
@@ -164,7 +164,7 @@ func importMastotoots(user *WhatAbout, source string) {
 				name := att.Name
 				desc := name
 				newurl := fmt.Sprintf("https://%s/d/%s", serverName, u)
-				fileid, err := savefile(name, desc, newurl, att.MediaType, true, data)
+				fileid, err := savefile(name, desc, newurl, att.MediaType, true, data, DonkMeta{})
 				if err != nil {
 					elog.Printf("error saving media: %s", fname)
 					continue
@@ -326,7 +326,7 @@ func importTwitter(username, source string) {
 			}
 			newurl := fmt.Sprintf("https://%s/d/%s", serverName, u)
 
-			fileid, err := savefile(u, u, newurl, "image/jpg", true, data)
+			fileid, err := savefile(u, u, newurl, "image/jpg", true, data, DonkMeta{})
 			if err != nil {
 				elog.Printf("error saving media: %s", fname)
 				continue
@@ -0,0 +1,514 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// Just enough of the Mastodon client API for phone apps.
+// Everything here is a translation onto the regular honk functions,
+// so the same rules apply as for the web and /api.
+// Statuses are identified by honkid, accounts by their xid.
+
+func mastoavatar(xid string) string {
+	return fmt.Sprintf("https://%s/a?a=%s", serverName, url.QueryEscape(xid))
+}
+
+func mastoaccount(xid string) junk.Junk {
+	handle, full := handles(xid)
+	if handle == "" {
+		handle, full = xid, xid
+	}
+	j := junk.New()
+	j["id"] = xid
+	j["username"] = handle
+	j["acct"] = full
+	j["display_name"] = handle
+	j["url"] = xid
+	j["avatar"] = mastoavatar(xid)
+	j["avatar_static"] = j["avatar"]
+	j["header"] = ""
+	j["header_static"] = ""
+	j["note"] = ""
+	j["locked"] = false
+	j["bot"] = false
+	j["created_at"] = time.Time{}.Format(time.RFC3339)
+	j["followers_count"] = 0
+	j["following_count"] = 0
+	j["statuses_count"] = 0
+	j["emojis"] = []junk.Junk{}
+	j["fields"] = []junk.Junk{}
+	return j
+}
+
+func mastouser(user *WhatAbout) junk.Junk {
+	j := mastoaccount(user.URL)
+	j["username"] = user.Name
+	j["acct"] = user.Name
+	j["display_name"] = user.Display
+	j["note"] = string(user.HTAbout)
+	if ava := user.Options.Avatar; ava != "" {
+		j["avatar"] = ava
+		j["avatar_static"] = ava
+	}
+	if ban := user.Options.Banner; ban != "" {
+		j["header"] = ban
+		j["header_static"] = ban
+	}
+	source := junk.New()
+	source["note"] = user.About
+	source["privacy"] = "public"
+	source["sensitive"] = false
+	source["fields"] = []junk.Junk{}
+	j["source"] = source
+	return j
+}
+
+func mastomedia(d *Donk) junk.Junk {
+	j := junk.New()
+	j["id"] = d.XID
+	switch {
+	case strings.HasPrefix(d.Media, "image/"):
+		j["type"] = "image"
+	case strings.HasPrefix(d.Media, "video/"):
+		j["type"] = "video"
+	case strings.HasPrefix(d.Media, "audio/"):
+		j["type"] = "audio"
+	default:
+		j["type"] = "unknown"
+	}
+	u := d.URL
+	if d.Local {
+		u = fmt.Sprintf("https://%s/d/%s", serverName, d.XID)
+	}
+	j["url"] = u
+	j["preview_url"] = u
+	j["remote_url"] = nil
+	j["description"] = d.Desc
+	if d.Meta.Blurhash != "" {
+		j["blurhash"] = d.Meta.Blurhash
+	}
+	if d.Meta.Width > 0 {
+		orig := junk.New()
+		orig["width"] = d.Meta.Width
+		orig["height"] = d.Meta.Height
+		meta := junk.New()
+		meta["original"] = orig
+		j["meta"] = meta
+	}
+	return j
+}
+
+// expects a reverbolated honk
+func mastostatus(userid int64, honk *Honk) junk.Junk {
+	j := junk.New()
+	j["id"] = strconv.FormatInt(honk.ID, 10)
+	j["uri"] = honk.XID
+	j["url"] = honk.URL
+	j["created_at"] = honk.Date.UTC().Format(time.RFC3339)
+	j["account"] = mastoaccount(honk.Honker)
+	j["content"] = string(honk.HTML)
+	j["spoiler_text"] = honk.Precis
+	j["sensitive"] = honk.Precis != ""
+	if honk.Public {
+		j["visibility"] = "public"
+	} else {
+		j["visibility"] = "direct"
+	}
+	j["in_reply_to_id"] = nil
+	j["in_reply_to_account_id"] = nil
+	if honk.RID != "" {
+		if xonk := getxonk(userid, honk.RID); xonk != nil {
+			j["in_reply_to_id"] = strconv.FormatInt(xonk.ID, 10)
+			j["in_reply_to_account_id"] = xonk.Honker
+		}
+	}
+	media := []junk.Junk{}
+	for _, d := range honk.Donks {
+		media = append(media, mastomedia(d))
+	}
+	j["media_attachments"] = media
+	mentions := []junk.Junk{}
+	for _, m := range honk.Mentions {
+		mj := junk.New()
+		mj["id"] = m.Where
+		mj["username"] = m.Who
+		mj["acct"] = strings.TrimPrefix(m.Who, "@")
+		mj["url"] = m.Where
+		mentions = append(mentions, mj)
+	}
+	j["mentions"] = mentions
+	tags := []junk.Junk{}
+	for _, o := range honk.Onts {
+		tj := junk.New()
+		tj["name"] = strings.TrimPrefix(o, "#")
+		tj["url"] = fmt.Sprintf("https://%s/o/%s", serverName, strings.ToLower(strings.TrimPrefix(o, "#")))
+		tags = append(tags, tj)
+	}
+	j["tags"] = tags
+	j["emojis"] = []junk.Junk{}
+	j["reblogs_count"] = 0
+	j["favourites_count"] = len(honk.Badonks)
+	j["replies_count"] = 0
+	j["favourited"] = honk.IsReacted()
+	j["reblogged"] = honk.IsBonked()
+	j["bookmarked"] = honk.IsSaved()
+	j["muted"] = false
+	j["pinned"] = false
+	j["language"] = nil
+	if honk.Lang != "" {
+		j["language"] = honk.Lang
+	}
+	j["reblog"] = nil
+	j["poll"] = nil
+	j["card"] = nil
+	j["application"] = nil
+	// a bonk is our status wrapped around theirs
+	if honk.Oonker != "" {
+		inner := junk.New()
+		for k, v := range j {
+			inner[k] = v
+		}
+		inner["account"] = mastoaccount(honk.Oonker)
+		j["content"] = ""
+		j["spoiler_text"] = ""
+		j["media_attachments"] = []junk.Junk{}
+		j["mentions"] = []junk.Junk{}
+		j["tags"] = []junk.Junk{}
+		j["reblog"] = inner
+	}
+	return j
+}
+
+func mastowrite(w http.ResponseWriter, what interface{}) {
+	j, err := jsonify(what)
+	if err != nil {
+		elog.Printf("error jsonifying: %s", err)
+		http.Error(w, "oops", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, j)
+}
+
+func mastoerror(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	j := junk.New()
+	j["error"] = msg
+	j.Write(w)
+}
+
+// the usual paging params, no max_id means from the top
+func mastopaging(r *http.Request) (since int64, maxid int64, limit int) {
+	since, _ = strconv.ParseInt(r.FormValue("since_id"), 10, 0)
+	if minid, _ := strconv.ParseInt(r.FormValue("min_id"), 10, 0); minid > since {
+		since = minid
+	}
+	maxid, _ = strconv.ParseInt(r.FormValue("max_id"), 10, 0)
+	if maxid <= 0 {
+		maxid = math.MaxInt64
+	}
+	limit, _ = strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 40 {
+		limit = 40
+	}
+	return
+}
+
+func mastolink(w http.ResponseWriter, r *http.Request, first, last int64) {
+	base := fmt.Sprintf("https://%s%s", serverName, r.URL.Path)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?max_id=%d>; rel="next", <%s?min_id=%d>; rel="prev"`,
+		base, last, base, first))
+}
+
+func mastoverify(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, err := butwhatabout(u.Username)
+	if err != nil {
+		mastoerror(w, "who are you", http.StatusUnauthorized)
+		return
+	}
+	mastowrite(w, mastouser(user))
+}
+
+func mastohome(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	since, maxid, limit := mastopaging(r)
+	honks := gethonksforuserbetween(u.UserID, since, maxid)
+	honks = osmosis(honks, u.UserID, true)
+	var picked []*Honk
+	for _, h := range honks {
+		picked = append(picked, h)
+		if len(picked) == limit {
+			break
+		}
+	}
+	reverbolate(u.UserID, picked)
+	statuses := []junk.Junk{}
+	for _, h := range picked {
+		statuses = append(statuses, mastostatus(u.UserID, h))
+	}
+	if len(picked) > 0 {
+		mastolink(w, r, picked[0].ID, picked[len(picked)-1].ID)
+	}
+	mastowrite(w, statuses)
+}
+
+var mastonotetypes = map[string]string{
+	"mention": "mention",
+	"reply":   "mention",
+	"follow":  "follow",
+	"bonk":    "reblog",
+	"react":   "favourite",
+	"poll":    "poll",
+}
+
+func mastonotifications(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	since, maxid, limit := mastopaging(r)
+	var notes []*Notification
+	for _, n := range getnotificationsbetween(u.UserID, since, maxid) {
+		notes = append(notes, n)
+		if len(notes) == limit {
+			break
+		}
+	}
+	results := []junk.Junk{}
+	for _, n := range notes {
+		j := junk.New()
+		j["id"] = strconv.FormatInt(n.ID, 10)
+		j["type"] = mastonotetypes[n.What]
+		j["created_at"] = n.Date.UTC().Format(time.RFC3339)
+		j["account"] = mastoaccount(n.Who)
+		if n.XID != "" {
+			if honk := getxonk(u.UserID, n.XID); honk != nil {
+				honks := []*Honk{honk}
+				donksforhonks(honks)
+				reverbolate(u.UserID, honks)
+				j["status"] = mastostatus(u.UserID, honk)
+			}
+		}
+		results = append(results, j)
+	}
+	if len(notes) > 0 {
+		mastolink(w, r, notes[0].ID, notes[len(notes)-1].ID)
+	}
+	mastowrite(w, results)
+}
+
+// clients send either form values or json, submithonk wants form values
+func mastoform(r *http.Request) error {
+	if strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/form-data") {
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := r.ParseForm()
+		if err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+		return nil
+	}
+	var buf bytes.Buffer
+	io.Copy(&buf, io.LimitReader(r.Body, 1*1024*1024))
+	j, err := junk.FromBytes(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	for k, v := range j {
+		switch v := v.(type) {
+		case string:
+			r.Form.Set(k, v)
+		case bool:
+			r.Form.Set(k, strconv.FormatBool(v))
+		case float64:
+			r.Form.Set(k, strconv.FormatFloat(v, 'f', -1, 64))
+		case []interface{}:
+			for _, x := range v {
+				if s, ok := x.(string); ok {
+					r.Form.Add(k+"[]", s)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func mastopost(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	err := mastoform(r)
+	if err != nil {
+		mastoerror(w, "can't read that", http.StatusBadRequest)
+		return
+	}
+	noise := r.Form.Get("status")
+	if spoiler := strings.TrimSpace(r.Form.Get("spoiler_text")); spoiler != "" {
+		noise = "DZ: " + spoiler + "\n" + noise
+	}
+	r.Form.Set("noise", noise)
+	r.Form.Set("format", "markdown")
+	if rid, _ := strconv.ParseInt(r.Form.Get("in_reply_to_id"), 10, 0); rid != 0 {
+		xonk := gethonkbyid(u.UserID, rid)
+		if xonk == nil {
+			mastoerror(w, "Record not found", http.StatusNotFound)
+			return
+		}
+		r.Form.Set("rid", xonk.XID)
+	}
+	for _, xid := range r.Form["media_ids[]"] {
+		r.Form.Add("donkxid", xid)
+	}
+	if sa := r.Form.Get("scheduled_at"); sa != "" {
+		when, err := time.Parse(time.RFC3339, sa)
+		if err != nil {
+			mastoerror(w, "bad scheduled_at", http.StatusUnprocessableEntity)
+			return
+		}
+		r.Form.Set("publishat", when.Local().Format("2006-01-02 15:04"))
+	}
+	honk := submithonk(w, r)
+	if honk == nil {
+		return
+	}
+	honks := []*Honk{honk}
+	reverbolate(u.UserID, honks)
+	mastowrite(w, mastostatus(u.UserID, honk))
+}
+
+func mastoonestatus(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	honkid, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	honk := gethonkbyid(u.UserID, honkid)
+	if honk == nil {
+		mastoerror(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	honks := []*Honk{honk}
+	donksforhonks(honks)
+	reverbolate(u.UserID, honks)
+	status := mastostatus(u.UserID, honk)
+	if r.Method == "DELETE" {
+		user, _ := butwhatabout(u.Username)
+		if honk.Honker != user.URL {
+			mastoerror(w, "Record not found", http.StatusNotFound)
+			return
+		}
+		r.Form = url.Values{}
+		r.Form.Set("wherefore", "zonk")
+		r.Form.Set("what", honk.XID)
+		zonkit(w, r)
+	}
+	mastowrite(w, status)
+}
+
+func mastoaction(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	honkid, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	honk := gethonkbyid(u.UserID, honkid)
+	if honk == nil {
+		mastoerror(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	r.Form = url.Values{}
+	r.Form.Set("what", honk.XID)
+	switch mux.Vars(r)["action"] {
+	case "favourite":
+		r.Form.Set("wherefore", "react")
+	case "reblog":
+		r.Form.Set("wherefore", "bonk")
+	}
+	zonkit(w, r)
+	if bonk := getbonk(u.UserID, honk.XID); bonk != nil && mux.Vars(r)["action"] == "reblog" {
+		honk = bonk
+	} else {
+		honk = gethonkbyid(u.UserID, honkid)
+	}
+	honks := []*Honk{honk}
+	donksforhonks(honks)
+	reverbolate(u.UserID, honks)
+	mastowrite(w, mastostatus(u.UserID, honk))
+}
+
+func mastoupload(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		mastoerror(w, "can't read that", http.StatusBadRequest)
+		return
+	}
+	file, filehdr, err := r.FormFile("file")
+	if err != nil {
+		mastoerror(w, "missing file", http.StatusUnprocessableEntity)
+		return
+	}
+	var buf bytes.Buffer
+	io.Copy(&buf, file)
+	file.Close()
+	d, err := submitdonk(buf.Bytes(), filehdr, r.FormValue("description"))
+	if err != nil {
+		derr := err.(*DonkError)
+		mastoerror(w, derr.Msg, derr.StatusCode)
+		return
+	}
+	if donk := finddonk(fmt.Sprintf("https://%s/d/%s", serverName, d.XID)); donk != nil {
+		d = donk
+	}
+	mastowrite(w, mastomedia(d))
+}
+
+func mastoinstance(w http.ResponseWriter, r *http.Request) {
+	j := junk.New()
+	j["uri"] = serverName
+	j["title"] = serverName
+	j["short_description"] = ""
+	j["description"] = string(serverMsg)
+	j["email"] = ""
+	j["version"] = "3.0.0 (compatible; honk " + softwareVersion + ")"
+	urls := junk.New()
+	urls["streaming_api"] = ""
+	j["urls"] = urls
+	stats := junk.New()
+	stats["user_count"] = len(allusers())
+	stats["status_count"] = 0
+	stats["domain_count"] = 0
+	j["stats"] = stats
+	j["thumbnail"] = nil
+	j["languages"] = []string{}
+	j["registrations"] = false
+	j["approval_required"] = false
+	j["invites_enabled"] = false
+	j["contact_account"] = nil
+	mastowrite(w, j)
+}
@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remote media we keep a copy of, in megabytes and days.
+// zero means no limit.
+var mediaCacheSize int64 = 0
+var mediaCacheDays = 0
+
+var touchedfiles = make(map[string]bool)
+var touchedfileslock sync.Mutex
+
+func touchfile(xid string) {
+	touchedfileslock.Lock()
+	touchedfiles[xid] = true
+	touchedfileslock.Unlock()
+}
+
+func flushtouches() {
+	touchedfileslock.Lock()
+	touched := touchedfiles
+	touchedfiles = make(map[string]bool)
+	touchedfileslock.Unlock()
+	dt := time.Now().UTC().Format(dbtimeformat)
+	for xid := range touched {
+		_, err := stmtTouchFileCache.Exec(dt, xid)
+		if err != nil {
+			elog.Printf("error touching file: %s", err)
+		}
+	}
+}
+
+func cachefile(xid string, size int) {
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtSaveFileCache.Exec(xid, size, dt)
+	if err != nil {
+		elog.Printf("error saving file cache: %s", err)
+	}
+}
+
+// uploads are forever, even if the same bytes came from elsewhere too
+func isuploaded(xid string) bool {
+	var n int64
+	row := stmtUploadedFile.QueryRow(xid, serverPrefix+"d/%")
+	err := row.Scan(&n)
+	if err != nil {
+		elog.Printf("error checking file: %s", err)
+		return true
+	}
+	return n > 0
+}
+
+func evictfile(xid string) {
+	if !isuploaded(xid) {
+		dlog.Printf("evicting cached file %s", xid)
+		err := zapblob(xid)
+		if err != nil {
+			elog.Printf("error evicting file: %s", err)
+			return
+		}
+		err = zapvariants(xid)
+		if err != nil {
+			elog.Printf("error evicting variants: %s", err)
+		}
+	}
+	_, err := stmtZapFileCache.Exec(xid)
+	if err != nil {
+		elog.Printf("error evicting file: %s", err)
+	}
+}
+
+func trimmediacache() {
+	flushtouches()
+	if mediaCacheDays > 0 {
+		when := time.Now().Add(-time.Duration(mediaCacheDays) * 24 * time.Hour).UTC().Format(dbtimeformat)
+		rows, err := stmtOldFileCache.Query(when)
+		if err != nil {
+			elog.Printf("error querying file cache: %s", err)
+			return
+		}
+		var xids []string
+		for rows.Next() {
+			var xid string
+			var size int64
+			err = rows.Scan(&xid, &size)
+			if err != nil {
+				elog.Printf("error scanning file cache: %s", err)
+				continue
+			}
+			xids = append(xids, xid)
+		}
+		rows.Close()
+		for _, xid := range xids {
+			evictfile(xid)
+		}
+	}
+	if mediaCacheSize > 0 {
+		budget := mediaCacheSize * 1024 * 1024
+		var total int64
+		row := stmtFileCacheSize.QueryRow()
+		err := row.Scan(&total)
+		if err != nil {
+			elog.Printf("error sizing file cache: %s", err)
+			return
+		}
+		if total <= budget {
+			return
+		}
+		ilog.Printf("trimming media cache from %d bytes", total)
+		rows, err := stmtOldFileCache.Query(time.Now().UTC().Format(dbtimeformat))
+		if err != nil {
+			elog.Printf("error querying file cache: %s", err)
+			return
+		}
+		var xids []string
+		for rows.Next() && total > budget {
+			var xid string
+			var size int64
+			err = rows.Scan(&xid, &size)
+			if err != nil {
+				elog.Printf("error scanning file cache: %s", err)
+				continue
+			}
+			xids = append(xids, xid)
+			total -= size
+		}
+		rows.Close()
+		for _, xid := range xids {
+			evictfile(xid)
+		}
+	}
+}
+
+func mediacachetrimmer() {
+	for {
+		time.Sleep(1 * time.Hour)
+		trimmediacache()
+	}
+}
+
+// we threw it away, but somebody wants it again
+func refetchfile(xid string) (string, []byte, bool) {
+	var url, media string
+	row := stmtRemoteFile.QueryRow(xid, serverPrefix+"d/%")
+	err := row.Scan(&url, &media)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			elog.Printf("error finding remote file: %s", err)
+		}
+		return "", nil, false
+	}
+	type refetched struct {
+		media string
+		data  []byte
+	}
+	fn := func() (interface{}, error) {
+		data, err := fetchsome(url)
+		if err != nil {
+			return nil, err
+		}
+		media := media
+		var meta DonkMeta
+		if strings.HasPrefix(media, "image") {
+			img, m, err := shrinkit(data)
+			if err != nil {
+				return nil, err
+			}
+			data = img.Data
+			media = "image/" + img.Format
+			meta = m
+		}
+		err = saveblob(xid, media, data)
+		if err != nil {
+			return nil, err
+		}
+		savevariants(xid, meta)
+		cachefile(xid, len(data))
+		return &refetched{media, data}, nil
+	}
+	ilog.Printf("refetching evicted file %s", url)
+	ii, err := flightdeck.Call("refetch "+xid, fn)
+	if err != nil {
+		ilog.Printf("error refetching file: %s", err)
+		return "", nil, false
+	}
+	rf := ii.(*refetched)
+	return rf.media, rf.data, true
+}
@@ -0,0 +1,113 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// A zonker is a muted convoy, a zonked honk that shouldn't come back,
+// or whatever else zonkit was asked to remember.
+type Zonker struct {
+	ID        int64
+	Name      string
+	Wherefore string
+	Expiry    time.Time
+	Honk      *Honk
+}
+
+func savezonker(userid int64, name string, wherefore string, expiry time.Time) error {
+	var exp string
+	if !expiry.IsZero() {
+		exp = expiry.UTC().Format(dbtimeformat)
+	}
+	_, err := stmtSaveZonker.Exec(userid, name, wherefore, exp)
+	return err
+}
+
+// grouped by wherefore, newest first
+func getzonkers(userid int64) map[string][]*Zonker {
+	rows, err := stmtGetZonkers.Query(userid)
+	if err != nil {
+		elog.Printf("error querying zonkers: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	zonkers := make(map[string][]*Zonker)
+	var convoys []*Zonker
+	for rows.Next() {
+		z := new(Zonker)
+		var exp string
+		err = rows.Scan(&z.ID, &z.Name, &z.Wherefore, &exp)
+		if err != nil {
+			elog.Printf("error scanning zonker: %s", err)
+			continue
+		}
+		if exp != "" {
+			z.Expiry, _ = time.Parse(dbtimeformat, exp)
+		}
+		zonkers[z.Wherefore] = append(zonkers[z.Wherefore], z)
+		if z.Wherefore == "zonvoy" {
+			convoys = append(convoys, z)
+		}
+	}
+	rows.Close()
+	// some context for what was muted
+	var honks []*Honk
+	for _, z := range convoys {
+		row := stmtFirstInConvoy.QueryRow(userid, z.Name)
+		z.Honk = scanhonk(row)
+		if z.Honk != nil {
+			honks = append(honks, z.Honk)
+		}
+	}
+	donksforhonks(honks)
+	reverbolate(userid, honks)
+	return zonkers
+}
+
+func unzonk(userid int64, zonkerid int64) error {
+	_, err := stmtDeleteZonker.Exec(userid, zonkerid)
+	return err
+}
+
+func setzonkerexpiry(userid int64, zonkerid int64, expiry time.Time) error {
+	var exp string
+	if !expiry.IsZero() {
+		exp = expiry.UTC().Format(dbtimeformat)
+	}
+	_, err := stmtZonkerExpiry.Exec(exp, userid, zonkerid)
+	return err
+}
+
+func expirezonkers(now time.Time) {
+	_, err := stmtExpireZonkers.Exec(now.UTC().Format(dbtimeformat))
+	if err != nil {
+		elog.Printf("error expiring zonkers: %s", err)
+	}
+}
+
+func nextzonkerexpiry() time.Time {
+	var j sql.NullString
+	row := stmtNextZonkerExpiry.QueryRow()
+	err := row.Scan(&j)
+	if err != nil || !j.Valid {
+		return time.Time{}
+	}
+	when, _ := time.Parse(dbtimeformat, j.String)
+	return when
+}
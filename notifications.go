@@ -0,0 +1,184 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+// Things that happened to us, one row per event.
+// The counter in the menu works like the @me one.
+type Notification struct {
+	ID     int64
+	What   string
+	Who    string
+	XID    string
+	Aux    string
+	Date   time.Time
+	Unseen bool
+}
+
+var notificationTypes = []string{"mention", "reply", "follow", "bonk", "react", "poll"}
+
+// several of the same thing about the same honk, shown together
+type NotificationGroup struct {
+	What   string
+	XID    string
+	Whos   []string
+	Auxes  []string
+	Date   time.Time
+	Unseen bool
+	Honk   *Honk
+}
+
+func notificationmuted(user *WhatAbout, what string) bool {
+	for _, m := range user.Options.MutedNotifications {
+		if m == what {
+			return true
+		}
+	}
+	return false
+}
+
+func notify(user *WhatAbout, what, who, xid, aux string) {
+	if who == user.URL || notificationmuted(user, what) {
+		return
+	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	_, err := stmtSaveNotification.Exec(user.ID, what, who, xid, aux, dt)
+	if err != nil {
+		elog.Printf("error saving notification: %s", err)
+		return
+	}
+	noteplusone(user.ID)
+}
+
+// some things only deserve telling once
+func notified(user *WhatAbout, what, who, xid string) bool {
+	var noteid int64
+	row := stmtFindNotification.QueryRow(user.ID, what, who, xid)
+	return row.Scan(&noteid) == nil
+}
+
+func getnotifications(userid int64, after int64) []*Notification {
+	rows, err := stmtGetNotifications.Query(userid, after)
+	return scannotifications(rows, err)
+}
+
+func getnotificationsbetween(userid int64, after int64, before int64) []*Notification {
+	rows, err := stmtGetNotificationsBetween.Query(userid, after, before)
+	return scannotifications(rows, err)
+}
+
+func scannotifications(rows *sql.Rows, err error) []*Notification {
+	if err != nil {
+		elog.Printf("error querying notifications: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var notes []*Notification
+	for rows.Next() {
+		n := new(Notification)
+		var dt string
+		err = rows.Scan(&n.ID, &n.What, &n.Who, &n.XID, &n.Aux, &dt, &n.Unseen)
+		if err != nil {
+			elog.Printf("error scanning notification: %s", err)
+			continue
+		}
+		n.Date, _ = time.Parse(dbtimeformat, dt)
+		notes = append(notes, n)
+	}
+	return notes
+}
+
+// follows all go together, everything else by what and which honk
+func groupnotifications(userid int64, notes []*Notification) []*NotificationGroup {
+	groups := make(map[string]*NotificationGroup)
+	var honks []*Honk
+	for _, n := range notes {
+		key := n.What + " " + n.XID
+		g := groups[key]
+		if g == nil {
+			g = &NotificationGroup{What: n.What, XID: n.XID, Date: n.Date}
+			if n.XID != "" {
+				g.Honk = getxonk(userid, n.XID)
+				if g.Honk != nil {
+					honks = append(honks, g.Honk)
+				}
+			}
+			groups[key] = g
+		}
+		g.Whos = append(g.Whos, n.Who)
+		if n.Aux != "" {
+			g.Auxes = append(g.Auxes, n.Aux)
+		}
+		if n.Date.After(g.Date) {
+			g.Date = n.Date
+		}
+		g.Unseen = g.Unseen || n.Unseen
+	}
+	donksforhonks(honks)
+	reverbolate(userid, honks)
+	var grouped []*NotificationGroup
+	for _, g := range groups {
+		grouped = append(grouped, g)
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		return grouped[i].Date.After(grouped[j].Date)
+	})
+	return grouped
+}
+
+func seenotifications(userid int64) {
+	_, err := stmtSeeNotifications.Exec(userid)
+	if err != nil {
+		elog.Printf("error marking notifications: %s", err)
+	}
+	notenewnone(userid)
+}
+
+// the only interesting update to a poll is that it's over
+func pollclosed(user *WhatAbout, obj junk.Junk, origin string) {
+	xid, _ := obj.GetString("id")
+	if originate(xid) != origin {
+		return
+	}
+	if _, ok := obj.GetString("closed"); !ok {
+		return
+	}
+	if getxonk(user.ID, xid) == nil {
+		return
+	}
+	who, _ := obj.GetString("attributedTo")
+	if notified(user, "poll", who, xid) {
+		return
+	}
+	notify(user, "poll", who, xid, "")
+}
+
+// someone answering in a thread we were part of
+func tookpart(user *WhatAbout, convoy string) bool {
+	if convoy == "" {
+		return false
+	}
+	var honkid int64
+	row := stmtTookPart.QueryRow(user.ID, convoy)
+	return row.Scan(&honkid) == nil
+}
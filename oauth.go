@@ -0,0 +1,432 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// OAuth2 authorization code flow, shaped the way Mastodon clients expect.
+// The tokens handed out are ordinary login tokens, saved in auth,
+// with a row in oauthgrants to remember which app has them
+// and what they may do.
+
+const oobRedirect = "urn:ietf:wg:oauth:2.0:oob"
+
+type OAuthApp struct {
+	ID       int64
+	ClientID string
+	Secret   string
+	Name     string
+	Redirect string
+	Website  string
+}
+
+type OAuthGrant struct {
+	ID      int64
+	AppName string
+	Scope   string
+	Date    time.Time
+}
+
+// hashed the same way the login package does it, so the token works
+// everywhere a login token does, and only the hash is kept
+func authhash(token string) string {
+	h := sha512.New512_256()
+	h.Write([]byte(token))
+	return fmt.Sprintf("%x", h.Sum(nil))[0:48]
+}
+
+func requesttoken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.FormValue("token")
+}
+
+// tokens that didn't come from an app can do anything
+func tokencanwrite(r *http.Request) bool {
+	token := requesttoken(r)
+	if token == "" {
+		return true
+	}
+	var scope string
+	row := stmtGrantScope.QueryRow(authhash(token))
+	err := row.Scan(&scope)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			elog.Printf("error scanning scope: %s", err)
+			return false
+		}
+		return true
+	}
+	return scopecanwrite(scope)
+}
+
+func scopecanwrite(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == "write" || strings.HasPrefix(s, "write:") {
+			return true
+		}
+	}
+	return false
+}
+
+// read tokens may look but not touch
+func writerequired(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && !tokencanwrite(r) {
+			mastoerror(w, "This action is outside the authorized scopes", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func getoauthapp(clientid string) *OAuthApp {
+	app := new(OAuthApp)
+	row := stmtGetOAuthApp.QueryRow(clientid)
+	err := row.Scan(&app.ID, &app.ClientID, &app.Secret, &app.Name, &app.Redirect, &app.Website)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			elog.Printf("error scanning app: %s", err)
+		}
+		return nil
+	}
+	return app
+}
+
+func getoauthgrants(userid int64) []*OAuthGrant {
+	rows, err := stmtGetOAuthGrants.Query(userid)
+	if err != nil {
+		elog.Printf("error querying grants: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var grants []*OAuthGrant
+	for rows.Next() {
+		g := new(OAuthGrant)
+		var dt string
+		err = rows.Scan(&g.ID, &g.AppName, &g.Scope, &dt)
+		if err != nil {
+			elog.Printf("error scanning grant: %s", err)
+			continue
+		}
+		g.Date, _ = time.Parse(dbtimeformat, dt)
+		grants = append(grants, g)
+	}
+	return grants
+}
+
+// takes the auth row along with it
+func revokegrant(userid int64, grantid int64) error {
+	var authid int64
+	row := stmtGrantAuth.QueryRow(grantid, userid)
+	err := row.Scan(&authid)
+	if err != nil {
+		return err
+	}
+	_, err = stmtDeleteAuth.Exec(authid, userid)
+	if err == nil {
+		_, err = stmtDeleteGrant.Exec(grantid, userid)
+	}
+	return err
+}
+
+func redirectallowed(app *OAuthApp, redirect string) bool {
+	for _, ru := range strings.Fields(app.Redirect) {
+		if ru == redirect {
+			return true
+		}
+	}
+	return false
+}
+
+// somewhere real to send the user back to, or nowhere at all
+func goodredirect(redirect string) bool {
+	if redirect == oobRedirect {
+		return true
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return false
+	}
+	return u.IsAbs() && u.Host != ""
+}
+
+func oauthapps(w http.ResponseWriter, r *http.Request) {
+	err := mastoform(r)
+	if err != nil {
+		mastoerror(w, "can't read that", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.Form.Get("client_name"))
+	redirect := strings.TrimSpace(r.Form.Get("redirect_uris"))
+	if name == "" || redirect == "" {
+		mastoerror(w, "need a client_name and redirect_uris", http.StatusUnprocessableEntity)
+		return
+	}
+	for _, ru := range strings.Fields(redirect) {
+		if !goodredirect(ru) {
+			mastoerror(w, "bad redirect_uris", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	app := &OAuthApp{
+		ClientID: xfiltrate(),
+		Secret:   xfiltrate() + xfiltrate(),
+		Name:     name,
+		Redirect: redirect,
+		Website:  strings.TrimSpace(r.Form.Get("website")),
+	}
+	dt := time.Now().UTC().Format(dbtimeformat)
+	res, err := stmtSaveOAuthApp.Exec(app.ClientID, app.Secret, app.Name, app.Redirect, app.Website, dt)
+	if err != nil {
+		elog.Printf("error saving app: %s", err)
+		mastoerror(w, "error saving app", http.StatusInternalServerError)
+		return
+	}
+	app.ID, _ = res.LastInsertId()
+	ilog.Printf("registered app %s", app.Name)
+	j := junk.New()
+	j["id"] = strconv.FormatInt(app.ID, 10)
+	j["name"] = app.Name
+	j["website"] = app.Website
+	j["redirect_uri"] = app.Redirect
+	j["client_id"] = app.ClientID
+	j["client_secret"] = app.Secret
+	mastowrite(w, j)
+}
+
+func showauthorize(w http.ResponseWriter, r *http.Request) {
+	app := getoauthapp(r.FormValue("client_id"))
+	if app == nil {
+		http.Error(w, "unknown app", http.StatusBadRequest)
+		return
+	}
+	redirect := r.FormValue("redirect_uri")
+	if !redirectallowed(app, redirect) {
+		http.Error(w, "bad redirect", http.StatusBadRequest)
+		return
+	}
+	if rt := r.FormValue("response_type"); rt != "" && rt != "code" {
+		http.Error(w, "only code is supported", http.StatusBadRequest)
+		return
+	}
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = "read"
+	}
+	templinfo := getInfo(r)
+	templinfo["App"] = app
+	templinfo["RedirectURI"] = redirect
+	templinfo["Scope"] = scope
+	templinfo["State"] = r.FormValue("state")
+	templinfo["OAuthCSRF"] = login.GetCSRF("oauth", r)
+	err := readviews.Execute(w, "authorize.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitauthorize(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	app := getoauthapp(r.FormValue("client_id"))
+	if app == nil {
+		http.Error(w, "unknown app", http.StatusBadRequest)
+		return
+	}
+	redirect := r.FormValue("redirect_uri")
+	if !redirectallowed(app, redirect) {
+		http.Error(w, "bad redirect", http.StatusBadRequest)
+		return
+	}
+	args := url.Values{}
+	if state := r.FormValue("state"); state != "" {
+		args.Set("state", state)
+	}
+	if r.FormValue("action") != "approve" {
+		args.Set("error", "access_denied")
+	} else {
+		code := xfiltrate()
+		dt := time.Now().UTC().Format(dbtimeformat)
+		_, err := stmtSaveGrant.Exec(app.ID, u.UserID, code, r.FormValue("scope"), dt, redirect)
+		if err != nil {
+			elog.Printf("error saving grant: %s", err)
+			http.Error(w, "error saving grant", http.StatusInternalServerError)
+			return
+		}
+		if redirect == oobRedirect {
+			templinfo := getInfo(r)
+			templinfo["ServerMessage"] = "Authorization code: " + code
+			err := readviews.Execute(w, "msg.html", templinfo)
+			if err != nil {
+				elog.Print(err)
+			}
+			return
+		}
+		args.Set("code", code)
+	}
+	if redirect == oobRedirect {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	sep := "?"
+	if strings.Contains(redirect, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, redirect+sep+args.Encode(), http.StatusSeeOther)
+}
+
+// the client authenticates itself with its id and secret
+func oauthclient(r *http.Request) *OAuthApp {
+	clientid, secret, ok := r.BasicAuth()
+	if !ok {
+		clientid = r.Form.Get("client_id")
+		secret = r.Form.Get("client_secret")
+	}
+	app := getoauthapp(clientid)
+	if app == nil || subtle.ConstantTimeCompare([]byte(app.Secret), []byte(secret)) != 1 {
+		return nil
+	}
+	return app
+}
+
+const codeLifetime = 10 * time.Minute
+
+func oauthtoken(w http.ResponseWriter, r *http.Request) {
+	err := mastoform(r)
+	if err != nil {
+		mastoerror(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("grant_type") != "authorization_code" {
+		mastoerror(w, "unsupported_grant_type", http.StatusBadRequest)
+		return
+	}
+	app := oauthclient(r)
+	if app == nil {
+		mastoerror(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	var grantid, userid int64
+	var scope, dt, redirect string
+	row := stmtFindGrant.QueryRow(app.ID, r.Form.Get("code"))
+	err = row.Scan(&grantid, &userid, &scope, &dt, &redirect)
+	if err != nil {
+		mastoerror(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	// must match what was asked for at authorize time
+	if r.Form.Get("redirect_uri") != redirect {
+		mastoerror(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	when, _ := time.Parse(dbtimeformat, dt)
+	if time.Since(when) > codeLifetime {
+		stmtDeleteGrant.Exec(grantid, userid)
+		mastoerror(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	token := xfiltrate() + xfiltrate()
+	expiry := time.Now().Add(1 * 365 * 24 * time.Hour).UTC().Format(dbtimeformat)
+	db := opendatabase()
+	tx, err := db.Begin()
+	if err != nil {
+		elog.Printf("can't begin tx: %s", err)
+		mastoerror(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	var taken bool
+	res, err := tx.Stmt(stmtSaveAuth).Exec(userid, authhash(token), expiry)
+	if err == nil {
+		authid, _ := res.LastInsertId()
+		// codes are good for one token, whoever gets here first
+		res, err = tx.Stmt(stmtUseGrant).Exec(authid, grantid)
+		if err == nil {
+			var n int64
+			n, err = res.RowsAffected()
+			taken = n != 1
+		}
+	}
+	if err == nil && !taken {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if taken {
+		mastoerror(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		elog.Printf("error saving token: %s", err)
+		mastoerror(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	ilog.Printf("issued token to %s for %d", app.Name, userid)
+	j := junk.New()
+	j["access_token"] = token
+	j["token_type"] = "Bearer"
+	j["scope"] = scope
+	j["created_at"] = time.Now().Unix()
+	mastowrite(w, j)
+}
+
+func oauthrevoke(w http.ResponseWriter, r *http.Request) {
+	err := mastoform(r)
+	if err != nil {
+		mastoerror(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	app := oauthclient(r)
+	if app == nil {
+		mastoerror(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	var grantid, userid int64
+	row := stmtFindGrantByAuth.QueryRow(app.ID, authhash(r.Form.Get("token")))
+	err = row.Scan(&grantid, &userid)
+	if err == nil {
+		err = revokegrant(userid, grantid)
+		if err != nil {
+			elog.Printf("error revoking token: %s", err)
+		}
+	}
+	// unknown tokens are already revoked, as far as anyone cares
+	mastowrite(w, junk.New())
+}
+
+func submitrevokeapp(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	grantid, _ := strconv.ParseInt(r.FormValue("grantid"), 10, 0)
+	err := revokegrant(u.UserID, grantid)
+	if err != nil {
+		elog.Printf("error revoking app: %s", err)
+	}
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestScopeCanWrite(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{"", false},
+		{"read", false},
+		{"read follow", false},
+		{"read write follow", true},
+		{"write:statuses", true},
+		{"read:statuses writer", false},
+	}
+	for _, test := range tests {
+		if got := scopecanwrite(test.scope); got != test.want {
+			t.Errorf("%q: got %v want %v", test.scope, got, test.want)
+		}
+	}
+}
+
+func TestGoodRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     bool
+	}{
+		{"urn:ietf:wg:oauth:2.0:oob", true},
+		{"https://app.example/callback", true},
+		{"myapp://oauth", true},
+		{"urn:ietf:wg:oauth:2.0:oobx", false},
+		{"javascript:alert(1)", false},
+		{"/callback", false},
+		{"https://", false},
+		{"no colon", false},
+	}
+	for _, test := range tests {
+		if got := goodredirect(test.redirect); got != test.want {
+			t.Errorf("%q: got %v want %v", test.redirect, got, test.want)
+		}
+	}
+}
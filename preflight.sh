@@ -15,5 +15,26 @@ if [ \! \( -e /usr/include/sqlite3.h -o -e /usr/local/include/sqlite3.h \) ] ; t
 	false
 fi
 
+cat > .fts5check.c << EOF
+#include <sqlite3.h>
+int
+main(void)
+{
+	sqlite3 *db;
+	if (sqlite3_open(":memory:", &db) != SQLITE_OK)
+		return 1;
+	if (sqlite3_exec(db, "create virtual table t using fts5(x)", 0, 0, 0) != SQLITE_OK)
+		return 1;
+	return 0;
+}
+EOF
+if ! ( ${CC:-cc} -I/usr/local/include -L/usr/local/lib -o .fts5check .fts5check.c -lsqlite3 && ./.fts5check ) > /dev/null 2>&1 ; then
+	rm -f .fts5check .fts5check.c
+	echo sqlite3 does not support fts5
+	echo please install a libsqlite3 built with fts5 enabled
+	false
+fi
+rm -f .fts5check .fts5check.c
+
 touch .preflightcheck
 
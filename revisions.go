@@ -0,0 +1,166 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// one step in the history, compared to the one before
+type Revision struct {
+	Date   time.Time
+	Precis template.HTML
+	Noise  template.HTML
+}
+
+// only so much history is worth keeping
+const maxRevisions = 20
+
+func getrevisions(honkid int64) []OldRevision {
+	rows, err := stmtGetRevisions.Query(honkid)
+	if err != nil {
+		elog.Printf("error querying revisions: %s", err)
+		return nil
+	}
+	defer rows.Close()
+	var revs []OldRevision
+	for rows.Next() {
+		var j string
+		var rev OldRevision
+		err = rows.Scan(&j)
+		if err == nil {
+			err = unjsonify(j, &rev)
+		}
+		if err != nil {
+			elog.Printf("error scanning revision: %s", err)
+			continue
+		}
+		revs = append(revs, rev)
+	}
+	return revs
+}
+
+// newest first, each one marked up against its predecessor
+func honkhistory(honk *Honk) []Revision {
+	revs := getrevisions(honk.ID)
+	revs = append(revs, OldRevision{Precis: honk.Precis, Noise: honk.Noise, Date: honk.Date})
+	var history []Revision
+	var prev OldRevision
+	for _, rev := range revs {
+		history = append(history, Revision{
+			Date:   rev.Date,
+			Precis: diffwords(searchtext(prev.Precis), searchtext(rev.Precis)),
+			Noise:  diffwords(searchtext(prev.Noise), searchtext(rev.Noise)),
+		})
+		prev = rev
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+var re_diffwords = regexp.MustCompile(`\s+|[^\s]+`)
+
+// past this, just show the changed part as replaced
+const maxDiffWords = 500
+
+func diffwords(a, b string) template.HTML {
+	aw := re_diffwords.FindAllString(a, -1)
+	bw := re_diffwords.FindAllString(b, -1)
+	var sb strings.Builder
+	del := func(s string) {
+		if strings.TrimSpace(s) == "" {
+			sb.WriteString(s)
+			return
+		}
+		sb.WriteString("<del>" + html.EscapeString(s) + "</del>")
+	}
+	ins := func(s string) {
+		if strings.TrimSpace(s) == "" {
+			sb.WriteString(s)
+			return
+		}
+		sb.WriteString("<ins>" + html.EscapeString(s) + "</ins>")
+	}
+	// edits are usually small, so only the middle needs real work
+	pre := 0
+	for pre < len(aw) && pre < len(bw) && aw[pre] == bw[pre] {
+		pre++
+	}
+	post := 0
+	for post < len(aw)-pre && post < len(bw)-pre && aw[len(aw)-1-post] == bw[len(bw)-1-post] {
+		post++
+	}
+	for _, w := range aw[:pre] {
+		sb.WriteString(html.EscapeString(w))
+	}
+	am := aw[pre : len(aw)-post]
+	bm := bw[pre : len(bw)-post]
+	if len(am) > maxDiffWords || len(bm) > maxDiffWords {
+		del(strings.Join(am, ""))
+		ins(strings.Join(bm, ""))
+	} else {
+		diffmiddle(am, bm, del, ins, &sb)
+	}
+	for _, w := range aw[len(aw)-post:] {
+		sb.WriteString(html.EscapeString(w))
+	}
+	return template.HTML(sb.String())
+}
+
+// longest common subsequence, from the back
+func diffmiddle(aw, bw []string, del, ins func(string), sb *strings.Builder) {
+	n, m := len(aw), len(bw)
+	w := m + 1
+	lcs := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if aw[i] == bw[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case aw[i] == bw[j]:
+			sb.WriteString(html.EscapeString(aw[i]))
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			del(aw[i])
+			i++
+		default:
+			ins(bw[j])
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		del(aw[i])
+	}
+	for ; j < m; j++ {
+		ins(bw[j])
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want template.HTML
+	}{
+		{"", "", ""},
+		{"same old words", "same old words", "same old words"},
+		{"", "brand new", "<ins>brand</ins> <ins>new</ins>"},
+		{"all gone", "", "<del>all</del> <del>gone</del>"},
+		{"the quick fox", "the slow fox", "the <del>quick</del><ins>slow</ins> fox"},
+		{"the fox", "the brown fox", "the <ins>brown</ins> fox"},
+		{"a b c d", "a c d e", "a <del>b</del> c d <ins>e</ins>"},
+		{"x < y", "x > y", "x <del>&lt;</del><ins>&gt;</ins> y"},
+	}
+	for _, test := range tests {
+		if got := diffwords(test.a, test.b); got != test.want {
+			t.Errorf("%q -> %q: got %q want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDiffWordsLong(t *testing.T) {
+	// a long honk with a small change is still a small diff
+	words := strings.Repeat("honk ", 5000)
+	got := string(diffwords(words+"goose", words+"moose"))
+	if want := words + "<del>goose</del><ins>moose</ins>"; got != want {
+		t.Errorf("small change: got %d bytes, %q at the end", len(got), got[len(got)-40:])
+	}
+	// too much change is shown as replaced wholesale
+	a := strings.Repeat("goose ", maxDiffWords)
+	b := strings.Repeat("moose ", maxDiffWords)
+	got = string(diffwords(a, b))
+	if want := "<del>" + strings.TrimSpace(a) + "</del><ins>" + strings.TrimSpace(b) + "</ins> "; got != want {
+		t.Errorf("big change: got %q", got[:40])
+	}
+}
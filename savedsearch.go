@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/cache"
+)
+
+type SavedSearch struct {
+	ID     int64 `json:"-"`
+	Name   string
+	Query  string
+	Date   time.Time
+	Seen   int64
+	Unread int64 `json:"-"`
+}
+
+var searchInvalidator cache.Invalidator
+
+var searchcache = cache.New(cache.Options{Filler: func(userid int64) ([]*SavedSearch, bool) {
+	rows, err := stmtGetSearches.Query(userid)
+	if err != nil {
+		elog.Printf("error querying searches: %s", err)
+		return nil, false
+	}
+	defer rows.Close()
+	var searches []*SavedSearch
+	for rows.Next() {
+		ss := new(SavedSearch)
+		var j string
+		err = rows.Scan(&ss.ID, &j)
+		if err == nil {
+			err = unjsonify(j, ss)
+		}
+		if err != nil {
+			elog.Printf("error scanning search: %s", err)
+			continue
+		}
+		searches = append(searches, ss)
+	}
+	sort.Slice(searches, func(i, j int) bool {
+		return searches[i].Name < searches[j].Name
+	})
+	return searches, true
+}, Invalidator: &searchInvalidator})
+
+func getsavedsearches(userid int64) []*SavedSearch {
+	var searches []*SavedSearch
+	searchcache.Get(userid, &searches)
+	return searches
+}
+
+func getsavedsearch(userid int64, name string) *SavedSearch {
+	for _, ss := range getsavedsearches(userid) {
+		if ss.Name == name {
+			return ss
+		}
+	}
+	return nil
+}
+
+// counting is a whole search, so don't do it every page load.
+// new honks clear the counts, and mutes catch up within the hour.
+var searchcountcache = cache.New(cache.Options{Filler: func(userid int64) ([]*SavedSearch, bool) {
+	var counted []*SavedSearch
+	for _, ss := range getsavedsearches(userid) {
+		c := *ss
+		c.Unread = countsearch(userid, ss.Query, ss.Seen)
+		counted = append(counted, &c)
+	}
+	return counted, true
+}, Invalidator: &searchInvalidator, Duration: 1 * time.Hour})
+
+func getsearchcounts(userid int64) []*SavedSearch {
+	var searches []*SavedSearch
+	searchcountcache.Get(userid, &searches)
+	return searches
+}
+
+const maxSearchCount = 100
+
+func countsearch(userid int64, q string, since int64) int64 {
+	queries := []string{"honks.honkid > ?", "honks.userid = ?"}
+	params := []interface{}{since, userid}
+	sq, sp, match := searchsql(userid, parsesearch(q))
+	queries = append(queries, sq...)
+	params = append(params, sp...)
+	queries = append(queries, butnotthosezonvoys)
+	params = append(params, userid)
+	from := " from honks "
+	if match != "" {
+		from += "join honksearch on honksearch.rowid = honks.honkid "
+		queries = append(queries, "honksearch match ?")
+		params = append(params, match)
+	}
+	params = append(params, maxSearchCount)
+	query := "select count(*) from (select honks.honkid" + from + "where " +
+		strings.Join(queries, " and ") + " limit ?)"
+	var n int64
+	row := opendatabase().QueryRow(query, params...)
+	err := row.Scan(&n)
+	if err != nil {
+		elog.Printf("error counting search: %s", err)
+	}
+	return n
+}
+
+func gethonksbysavedsearch(userid int64, ss *SavedSearch, wanted int64) []*Honk {
+	return searchhonks(userid, ss.Query, wanted, false)
+}
+
+func savesearch(userid int64, ss *SavedSearch) error {
+	j, err := jsonify(ss)
+	if err == nil {
+		if ss.ID == 0 {
+			_, err = stmtSaveSearch.Exec(userid, j)
+		} else {
+			_, err = stmtUpdateSearch.Exec(j, ss.ID, userid)
+		}
+	}
+	searchInvalidator.Clear(userid)
+	return err
+}
+
+// looking at the page means it's all been read
+func seensearch(userid int64, ss *SavedSearch, honks []*Honk) {
+	if len(honks) == 0 || honks[0].ID <= ss.Seen {
+		return
+	}
+	c := *ss
+	c.Seen = honks[0].ID
+	err := savesearch(userid, &c)
+	if err != nil {
+		elog.Printf("error updating search: %s", err)
+	}
+}
@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Scheduled honks are saved with whofore 4 and the publish time as dt.
+// Nothing leaves the server until the scheduler flips them to 2 or 3.
+// Expiring honks have an expires meta, and get zonked when it passes.
+// Expiring mutes just go away.
+
+var schedulechan = make(chan int, 1)
+
+func pokescheduler() {
+	select {
+	case schedulechan <- 0:
+	default:
+	}
+}
+
+func getscheduledhonks(userid int64) []*Honk {
+	rows, err := stmtScheduledHonks.Query(userid, userid)
+	return getsomehonks(rows, err)
+}
+
+func publishscheduled(honk *Honk) {
+	user, err := butwhatabout(honk.Username)
+	if err != nil {
+		elog.Printf("no user for scheduled honk: %s", honk.XID)
+		return
+	}
+	if honk.Public {
+		honk.Whofore = 2
+	} else {
+		honk.Whofore = 3
+	}
+	honk.Date = time.Now().UTC()
+	_, err = stmtPublishHonk.Exec(honk.Whofore, honk.Date.Format(dbtimeformat), honk.ID)
+	if err != nil {
+		elog.Printf("error publishing honk: %s", err)
+		return
+	}
+	oldjonks.Clear(honk.XID)
+	streamhonk(honk)
+	ilog.Printf("publishing scheduled honk %s", honk.XID)
+	honkworldwide(user, honk)
+	honkhonkline()
+}
+
+func honkexpiry(honkid int64) time.Time {
+	var j string
+	row := stmtGetOneMeta.QueryRow(honkid, "expires")
+	err := row.Scan(&j)
+	if err != nil {
+		return time.Time{}
+	}
+	when, _ := time.Parse(dbtimeformat, j)
+	return when
+}
+
+func nextexpiry() time.Time {
+	var j sql.NullString
+	row := stmtNextExpiry.QueryRow()
+	err := row.Scan(&j)
+	if err != nil || !j.Valid {
+		return time.Time{}
+	}
+	when, _ := time.Parse(dbtimeformat, j.String)
+	return when
+}
+
+// same as a manual zonk, backtracks included
+func expirehonks(now time.Time) {
+	rows, err := stmtExpiredHonks.Query(now.UTC().Format(dbtimeformat))
+	honks := getsomehonks(rows, err)
+	for _, honk := range honks {
+		user, err := butwhatabout(honk.Username)
+		if err != nil {
+			continue
+		}
+		ilog.Printf("expiring honk %s", honk.XID)
+		err = deletehonk(honk.ID)
+		if err != nil {
+			continue
+		}
+		oldjonks.Clear(honk.XID)
+		sendzonkofsorts(honk, user, "zonk", "")
+	}
+}
+
+func scheduler() {
+	sleeper := time.NewTimer(5 * time.Second)
+	for {
+		select {
+		case <-schedulechan:
+			if !sleeper.Stop() {
+				<-sleeper.C
+			}
+		case <-sleeper.C:
+		}
+
+		now := time.Now()
+		nexttime := now.Add(1 * time.Hour)
+		for _, honk := range getscheduledhonks(-1) {
+			if honk.Date.After(now) {
+				if honk.Date.Before(nexttime) {
+					nexttime = honk.Date
+				}
+				continue
+			}
+			publishscheduled(honk)
+		}
+		expirehonks(now)
+		if when := nextexpiry(); !when.IsZero() && when.Before(nexttime) {
+			nexttime = when
+		}
+		expirezonkers(now)
+		if when := nextzonkerexpiry(); !when.IsZero() && when.Before(nexttime) {
+			nexttime = when
+		}
+		dur := 5 * time.Second
+		if now.Before(nexttime) {
+			dur += nexttime.Sub(now)
+		}
+		sleeper.Reset(dur)
+	}
+}
@@ -2,15 +2,23 @@
 create table honks (honkid integer primary key, userid integer, what text, honker text, xid text, rid text, dt text, url text, audience text, noise text, convoy text, whofore integer, format text, precis text, oonker text, flags integer);
 create table chonks (chonkid integer primary key, userid integer, xid text, who txt, target text, dt text, noise text, format text);
 create table donks (honkid integer, chonkid integer, fileid integer);
-create table filemeta (fileid integer primary key, xid text, name text, description text, url text, media text, local integer);
+create table filemeta (fileid integer primary key, xid text, name text, description text, url text, media text, local integer, meta text);
 create table honkers (honkerid integer primary key, userid integer, name text, xid text, flavor text, combos text, owner text, meta text, folxid text);
 create table xonkers (xonkerid integer primary key, name text, info text, flavor text, dt text);
-create table zonkers (zonkerid integer primary key, userid integer, name text, wherefore text);
+create table zonkers (zonkerid integer primary key, userid integer, name text, wherefore text, expiry text);
 create table doovers(dooverid integer primary key, dt text, tries integer, userid integer, rcpt text, msg blob);
+create table deliveries (deliveryid integer primary key, userid integer, xid text, rcpt text, inbox text, status integer, tries integer, dt text, lasterr text, dooverid integer, msg blob);
 create table onts (ontology text, honkid integer);
 create table honkmeta (honkid integer, genus text, json text);
 create table hfcs (hfcsid integer primary key, userid integer, json text);
+create table searches (searchid integer primary key, userid integer, json text);
+create table drafts (draftid integer primary key, userid integer, dt text, json text);
+create table notifications (notifyid integer primary key, userid integer, what text, who text, xid text, aux text, dt text, unseen integer);
+create table oauthapps (appid integer primary key, clientid text, secret text, name text, redirect text, website text, dt text);
+create table oauthgrants (grantid integer primary key, appid integer, userid integer, code text, scope text, dt text, authid integer, redirect text);
 create table tracks (xid text, fetches text);
+create table filecache (xid text, size integer, dt text);
+create virtual table honksearch using fts5(noise, precis, handle, descs, tokenize = 'porter unicode61 remove_diacritics 2');
 
 create index idx_honksxid on honks(xid);
 create index idx_honksconvoy on honks(convoy);
@@ -27,7 +35,14 @@ create index idx_ontology on onts(ontology);
 create index idx_onthonkid on onts(honkid);
 create index idx_honkmetaid on honkmeta(honkid);
 create index idx_hfcsuser on hfcs(userid);
+create index idx_searchesuser on searches(userid);
+create index idx_draftsuser on drafts(userid);
+create index idx_notificationsuser on notifications(userid);
+create index idx_oauthappsclient on oauthapps(clientid);
+create index idx_oauthgrantsuser on oauthgrants(userid);
 create index idx_trackhonkid on tracks(xid);
+create index idx_deliveriesxid on deliveries(xid);
+create index idx_filecachexid on filecache(xid);
 
 create table config (key text, value text);
 
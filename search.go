@@ -0,0 +1,355 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var re_searchtags = regexp.MustCompile(`<[^>]*>`)
+
+// plain text for the index, not for display
+func searchtext(s string) string {
+	s = re_searchtags.ReplaceAllString(s, " ")
+	return html.UnescapeString(s)
+}
+
+func searchhandle(xid string, lookup func(string) string) string {
+	if xid == "" {
+		return ""
+	}
+	if originate(xid) == serverName {
+		idx := strings.LastIndexByte(xid, '/')
+		return xid[idx+1:] + "@" + serverName
+	}
+	handle := lookup(xid)
+	if handle == "" {
+		return ""
+	}
+	return handle + "@" + originate(xid)
+}
+
+func cachedhandle(xid string) string {
+	return getxonker(xid, "handle")
+}
+
+// call after saveextras, so the donks are there
+func indexhonk(tx *sql.Tx, h *Honk) error {
+	_, err := tx.Stmt(stmtUnindexHonk).Exec(h.ID)
+	if err != nil {
+		return err
+	}
+	handle := searchhandle(h.Honker, cachedhandle)
+	if h.Oonker != "" {
+		handle += " " + searchhandle(h.Oonker, cachedhandle)
+	}
+	_, err = tx.Stmt(stmtIndexHonk).Exec(h.ID, searchtext(h.Noise), searchtext(h.Precis), handle, h.ID)
+	if err != nil {
+		elog.Printf("error indexing honk: %s", err)
+	}
+	return err
+}
+
+// fill in the index for old honks.
+// runs during upgrade, before statements are prepared.
+func reindexhonks(db *sql.DB) {
+	lookup := func(xid string) string {
+		var handle string
+		row := db.QueryRow("select info from xonkers where name = ? and flavor = 'handle'", xid)
+		row.Scan(&handle)
+		return handle
+	}
+	var lastid int64
+	for {
+		rows, err := db.Query("select honkid, honker, oonker, noise, precis from honks where honkid > ? order by honkid limit 1000", lastid)
+		if err != nil {
+			elog.Fatal(err)
+		}
+		var honks []*Honk
+		for rows.Next() {
+			h := new(Honk)
+			err = rows.Scan(&h.ID, &h.Honker, &h.Oonker, &h.Noise, &h.Precis)
+			if err != nil {
+				elog.Fatal(err)
+			}
+			honks = append(honks, h)
+		}
+		rows.Close()
+		if len(honks) == 0 {
+			break
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			elog.Fatal(err)
+		}
+		for _, h := range honks {
+			handle := searchhandle(h.Honker, lookup)
+			if h.Oonker != "" {
+				handle += " " + searchhandle(h.Oonker, lookup)
+			}
+			doordie(tx, "delete from honksearch where rowid = ?", h.ID)
+			doordie(tx, "insert into honksearch (rowid, noise, precis, handle, descs) values (?, ?, ?, ?, (select group_concat(description, ' ') from donks join filemeta on donks.fileid = filemeta.fileid where donks.honkid = ?))",
+				h.ID, searchtext(h.Noise), searchtext(h.Precis), handle, h.ID)
+			lastid = h.ID
+		}
+		err = tx.Commit()
+		if err != nil {
+			elog.Fatal(err)
+		}
+	}
+}
+
+// every word is a phrase, so users can't stumble into fts syntax
+func searchphrase(t string) string {
+	return `"` + strings.Replace(t, `"`, `""`, -1) + `"`
+}
+
+// mark the matches, escape everything else
+const snipOpen = "\x02"
+const snipClose = "\x03"
+
+func snippetize(s string) template.HTML {
+	s = html.EscapeString(s)
+	s = strings.Replace(s, snipOpen, "<mark>", -1)
+	s = strings.Replace(s, snipClose, "</mark>", -1)
+	return template.HTML(s)
+}
+
+type snippetrow struct {
+	*sql.Rows
+	snip *string
+}
+
+func (row snippetrow) Scan(dest ...interface{}) error {
+	return row.Rows.Scan(append(dest, row.snip)...)
+}
+
+// A search is a list of conditions, all of which must match.
+type SearchNode struct {
+	Op     string
+	Arg    string
+	Negate bool
+}
+
+var searchOps = map[string]bool{
+	"site": true, "honker": true, "before": true, "after": true,
+	"has": true, "is": true, "ont": true, "combo": true,
+	"convoy": true, "lang": true,
+}
+
+func parsesearch(q string) []SearchNode {
+	var nodes []SearchNode
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		if r[i] == ' ' || r[i] == '\t' || r[i] == '\n' {
+			i++
+			continue
+		}
+		var node SearchNode
+		if r[i] == '-' {
+			node.Negate = true
+			i++
+		}
+		var tok []rune
+		quoted := false
+		for i < len(r) && r[i] != ' ' && r[i] != '\t' && r[i] != '\n' {
+			if r[i] == '"' {
+				quoted = true
+				i++
+				for i < len(r) && r[i] != '"' {
+					tok = append(tok, r[i])
+					i++
+				}
+				i++
+				continue
+			}
+			tok = append(tok, r[i])
+			i++
+		}
+		t := string(tok)
+		if t == "" {
+			continue
+		}
+		node.Op = "term"
+		node.Arg = t
+		if quoted {
+			node.Op = "phrase"
+		}
+		// "site:example.com" in quotes is just words
+		if idx := strings.IndexByte(t, ':'); !quoted && idx > 0 && searchOps[t[:idx]] {
+			node.Op = t[:idx]
+			node.Arg = t[idx+1:]
+			if node.Arg == "" {
+				continue
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// the where clauses, their params, and the fts match expression
+func searchsql(userid int64, nodes []SearchNode) ([]string, []interface{}, string) {
+	var queries []string
+	var params []interface{}
+	var matches []string
+	for _, node := range nodes {
+		var query string
+		var args []interface{}
+		switch node.Op {
+		case "term", "phrase":
+			if !node.Negate {
+				matches = append(matches, searchphrase(node.Arg))
+				continue
+			}
+			query = "honks.honkid in (select rowid from honksearch where honksearch match ?)"
+			args = append(args, searchphrase(node.Arg))
+		case "site":
+			query = "honks.xid like ?"
+			args = append(args, "%"+node.Arg+"%")
+		case "honker":
+			honker := node.Arg
+			xid := fullname(honker, userid)
+			if xid != "" {
+				honker = xid
+			}
+			query = "(honks.honker = ? or honks.oonker = ?)"
+			args = append(args, honker, honker)
+		case "before", "after":
+			when, err := time.ParseInLocation("2006-01-02", node.Arg, time.Local)
+			if err != nil {
+				ilog.Printf("bad search date: %s", node.Arg)
+				continue
+			}
+			if node.Op == "before" {
+				query = "honks.dt < ?"
+			} else {
+				query = "honks.dt >= ?"
+			}
+			args = append(args, when.UTC().Format(dbtimeformat))
+		case "has":
+			switch node.Arg {
+			case "media":
+				query = "honks.honkid in (select honkid from donks)"
+			case "poll":
+				query = "honks.what = ?"
+				args = append(args, "qonk")
+			default:
+				continue
+			}
+		case "is":
+			switch node.Arg {
+			case "dm":
+				query = "(honks.audience not like ? and honks.audience not like ?)"
+				args = append(args, "%"+thewholeworld+"%", "%/followers%")
+			case "saved":
+				query = "honks.flags & ? != 0"
+				args = append(args, flagIsSaved)
+			case "bonk":
+				query = "honks.what = ?"
+				args = append(args, "bonk")
+			default:
+				continue
+			}
+		case "ont":
+			ont := strings.ToLower(node.Arg)
+			if ont[0] != '#' {
+				ont = "#" + ont
+			}
+			query = "honks.honkid in (select honkid from onts where ontology = ?)"
+			args = append(args, ont)
+		case "combo":
+			combo := "% " + node.Arg + " %"
+			query = "(honks.honker in (select xid from honkers where honkers.userid = ? and honkers.combos like ?) or honks.honkid in (select honkid from onts where ontology in (select xid from honkers where honkers.userid = ? and honkers.combos like ?)))"
+			args = append(args, userid, combo, userid, combo)
+		case "convoy":
+			query = "honks.convoy = ?"
+			args = append(args, node.Arg)
+		case "lang":
+			lang := strings.ToLower(node.Arg)
+			query = "honks.honkid in (select honkid from honkmeta where genus = 'lang' and (json = ? or json like ?))"
+			args = append(args, lang, lang+"-%")
+		default:
+			continue
+		}
+		if node.Negate {
+			query = "not (" + query + ")"
+		}
+		queries = append(queries, query)
+		params = append(params, args...)
+	}
+	return queries, params, strings.Join(matches, " ")
+}
+
+// searches leave out muted threads, same as the timelines
+const butnotthosezonvoys = "honks.convoy not in (select name from zonkers where userid = ? and wherefore = 'zonvoy')"
+
+func gethonksbysearch(userid int64, q string, wanted int64) []*Honk {
+	return searchhonks(userid, q, wanted, true)
+}
+
+// timelines want the newest first, not the best match
+func searchhonks(userid int64, q string, wanted int64, ranked bool) []*Honk {
+	var queries []string
+	var params []interface{}
+	queries = append(queries, "honks.honkid > ?")
+	params = append(params, wanted)
+	queries = append(queries, "honks.userid = ?")
+	params = append(params, userid)
+
+	sq, sp, match := searchsql(userid, parsesearch(q))
+	queries = append(queries, sq...)
+	params = append(params, sp...)
+
+	selecthonks := "select honks.honkid, honks.userid, username, what, honker, oonker, honks.xid, rid, dt, url, audience, honks.noise, honks.precis, format, convoy, whofore, flags"
+	from := " from honks join users on honks.userid = users.userid "
+	limit := " order by honks.honkid desc limit 250"
+	if match != "" {
+		selecthonks += ", snippet(honksearch, -1, ?, ?, '...', 24)"
+		params = append([]interface{}{snipOpen, snipClose}, params...)
+		from += "join honksearch on honksearch.rowid = honks.honkid "
+		queries = append(queries, "honksearch match ?")
+		params = append(params, match)
+		if ranked {
+			limit = " order by honksearch.rank, honks.honkid desc limit 250"
+		}
+	}
+	queries = append(queries, butnotthosezonvoys)
+	params = append(params, userid)
+	where := "where " + strings.Join(queries, " and ")
+	rows, err := opendatabase().Query(selecthonks+from+where+limit, params...)
+	if err != nil || match == "" {
+		return getsomehonks(rows, err)
+	}
+	defer rows.Close()
+	var honks []*Honk
+	for rows.Next() {
+		var snip string
+		h := scanhonk(snippetrow{rows, &snip})
+		if h != nil {
+			h.Snippet = snippetize(snip)
+			honks = append(honks, h)
+		}
+	}
+	rows.Close()
+	donksforhonks(honks)
+	return honks
+}
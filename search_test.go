@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []SearchNode
+	}{
+		{"", nil},
+		{"moose", []SearchNode{{Op: "term", Arg: "moose"}}},
+		{"  big\tmoose\n", []SearchNode{{Op: "term", Arg: "big"}, {Op: "term", Arg: "moose"}}},
+		{`"big moose"`, []SearchNode{{Op: "phrase", Arg: "big moose"}}},
+		{`-"big moose"`, []SearchNode{{Op: "phrase", Arg: "big moose", Negate: true}}},
+		{"-footloose", []SearchNode{{Op: "term", Arg: "footloose", Negate: true}}},
+		{"site:example.com", []SearchNode{{Op: "site", Arg: "example.com"}}},
+		{"-is:bonk", []SearchNode{{Op: "is", Arg: "bonk", Negate: true}}},
+		{`honker:goose "big moose" -footloose after:2023-01-01`, []SearchNode{
+			{Op: "honker", Arg: "goose"},
+			{Op: "phrase", Arg: "big moose"},
+			{Op: "term", Arg: "footloose", Negate: true},
+			{Op: "after", Arg: "2023-01-01"},
+		}},
+		// quoted and unknown operators are only words
+		{`"site:example.com"`, []SearchNode{{Op: "phrase", Arg: "site:example.com"}}},
+		{`"site:"example.com`, []SearchNode{{Op: "phrase", Arg: "site:example.com"}}},
+		{"moose:goose", []SearchNode{{Op: "term", Arg: "moose:goose"}}},
+		// nothing to look for
+		{`site: - ""`, nil},
+		{`"unterminated quote`, []SearchNode{{Op: "phrase", Arg: "unterminated quote"}}},
+	}
+	for _, test := range tests {
+		got := parsesearch(test.q)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v want %+v", test.q, got, test.want)
+		}
+	}
+}
+
+func TestSearchSQL(t *testing.T) {
+	ilog = log.Default()
+	day := func(s string) string {
+		when, _ := time.ParseInLocation("2006-01-02", s, time.Local)
+		return when.UTC().Format(dbtimeformat)
+	}
+	tests := []struct {
+		q       string
+		queries []string
+		params  []interface{}
+		match   string
+	}{
+		{"big moose", nil, nil, `"big" "moose"`},
+		{`"big moose"`, nil, nil, `"big moose"`},
+		{`say "hi"`, nil, nil, `"say" "hi"`},
+		{`-"big moose"`,
+			[]string{"not (honks.honkid in (select rowid from honksearch where honksearch match ?))"},
+			[]interface{}{`"big moose"`}, ""},
+		{"site:example.com",
+			[]string{"honks.xid like ?"},
+			[]interface{}{"%example.com%"}, ""},
+		{"before:2023-04-01",
+			[]string{"honks.dt < ?"},
+			[]interface{}{day("2023-04-01")}, ""},
+		{"after:2023-04-01",
+			[]string{"honks.dt >= ?"},
+			[]interface{}{day("2023-04-01")}, ""},
+		{"after:yesterday", nil, nil, ""},
+		{"has:media",
+			[]string{"honks.honkid in (select honkid from donks)"},
+			nil, ""},
+		{"-has:poll",
+			[]string{"not (honks.what = ?)"},
+			[]interface{}{"qonk"}, ""},
+		{"has:feathers", nil, nil, ""},
+		{"is:saved",
+			[]string{"honks.flags & ? != 0"},
+			[]interface{}{flagIsSaved}, ""},
+		{"ont:Moose",
+			[]string{"honks.honkid in (select honkid from onts where ontology = ?)"},
+			[]interface{}{"#moose"}, ""},
+		{"combo:birds",
+			[]string{"(honks.honker in (select xid from honkers where honkers.userid = ? and honkers.combos like ?) or honks.honkid in (select honkid from onts where ontology in (select xid from honkers where honkers.userid = ? and honkers.combos like ?)))"},
+			[]interface{}{int64(1), "% birds %", int64(1), "% birds %"}, ""},
+		{"convoy:data:,abc",
+			[]string{"honks.convoy = ?"},
+			[]interface{}{"data:,abc"}, ""},
+		{"lang:EN",
+			[]string{"honks.honkid in (select honkid from honkmeta where genus = 'lang' and (json = ? or json like ?))"},
+			[]interface{}{"en", "en-%"}, ""},
+		{"moose is:bonk",
+			[]string{"honks.what = ?"},
+			[]interface{}{"bonk"}, `"moose"`},
+	}
+	for _, test := range tests {
+		queries, params, match := searchsql(1, parsesearch(test.q))
+		if !reflect.DeepEqual(queries, test.queries) {
+			t.Errorf("%q queries: got %q want %q", test.q, queries, test.queries)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%q params: got %v want %v", test.q, params, test.params)
+		}
+		if match != test.match {
+			t.Errorf("%q match: got %s want %s", test.q, match, test.match)
+		}
+	}
+}
+
+func TestContentLang(t *testing.T) {
+	tests := []struct {
+		cmap junk.Junk
+		want string
+	}{
+		{junk.Junk{}, ""},
+		{junk.Junk{"en": ""}, "en"},
+		{junk.Junk{"fr": "", "EN": "", "de-AT": ""}, "de-at"},
+		{junk.Junk{"Fr": "", "en": ""}, "en"},
+		{junk.Junk{"": "", "averyveryverylonglanguage": "", "nl": ""}, "nl"},
+	}
+	for _, test := range tests {
+		// maps come out in any order, so ask a few times
+		for i := 0; i < 10; i++ {
+			if got := contentlang(test.cmap); got != test.want {
+				t.Errorf("%v: got %s want %s", test.cmap, got, test.want)
+				break
+			}
+		}
+	}
+}
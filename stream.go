@@ -0,0 +1,184 @@
+//
+// Copyright (c) 2019 Ted Unangst <tedu@tedunangst.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"humungus.tedunangst.com/r/webs/junk"
+	"humungus.tedunangst.com/r/webs/login"
+)
+
+// Server-Sent Events, one streamer per open connection.
+// Unlike honkline, only the user a honk was saved for hears about it,
+// and it's already been through the same filters as the timeline.
+
+type streamEvent struct {
+	what string
+	data string
+}
+
+type streamer struct {
+	userid int64
+	events chan streamEvent
+}
+
+var streamlock sync.Mutex
+var streamers = make(map[int64][]*streamer)
+
+func addstreamer(userid int64) *streamer {
+	s := &streamer{userid: userid, events: make(chan streamEvent, 32)}
+	streamlock.Lock()
+	streamers[userid] = append(streamers[userid], s)
+	streamlock.Unlock()
+	return s
+}
+
+func dropstreamer(s *streamer) {
+	streamlock.Lock()
+	defer streamlock.Unlock()
+	ss := streamers[s.userid]
+	for i, x := range ss {
+		if x == s {
+			ss = append(ss[:i], ss[i+1:]...)
+			break
+		}
+	}
+	if len(ss) == 0 {
+		delete(streamers, s.userid)
+	} else {
+		streamers[s.userid] = ss
+	}
+}
+
+func streaming(userid int64) bool {
+	streamlock.Lock()
+	defer streamlock.Unlock()
+	return len(streamers[userid]) > 0
+}
+
+// slow readers miss out rather than hold up saving
+func streamto(userid int64, what string, data interface{}) {
+	j, err := jsonify(data)
+	if err != nil {
+		elog.Printf("error jsonifying event: %s", err)
+		return
+	}
+	ev := streamEvent{what: what, data: strings.TrimSpace(j)}
+	streamlock.Lock()
+	defer streamlock.Unlock()
+	for _, s := range streamers[userid] {
+		select {
+		case s.events <- ev:
+		default:
+			dlog.Printf("dropping %s event for %d", what, userid)
+		}
+	}
+}
+
+func streamcounts(userid int64) {
+	if !streaming(userid) {
+		return
+	}
+	var user *WhatAbout
+	ok := somenumberedusers.Get(userid, &user)
+	if !ok {
+		return
+	}
+	streamto(userid, "counts", countsjunk(user))
+}
+
+func countsjunk(user *WhatAbout) junk.Junk {
+	j := junk.New()
+	j["MeCount"] = user.Options.MeCount
+	j["ChatCount"] = user.Options.ChatCount
+	j["NoteCount"] = user.Options.NoteCount
+	return j
+}
+
+func streamhonk(h *Honk) {
+	if h.Whofore == 4 || !streaming(h.UserID) {
+		return
+	}
+	// a fresh copy to dress up
+	honk := getxonk(h.UserID, h.XID)
+	if honk == nil {
+		return
+	}
+	honks := osmosis([]*Honk{honk}, h.UserID, true)
+	if len(honks) == 0 {
+		return
+	}
+	donksforhonks(honks)
+	reverbolate(h.UserID, honks)
+	streamto(h.UserID, "honk", honks[0])
+	if h.Whofore == 1 {
+		streamcounts(h.UserID)
+	}
+}
+
+func streamchonk(ch *Chonk) {
+	if !streaming(ch.UserID) {
+		return
+	}
+	c := *ch
+	c.Donks = append([]*Donk(nil), ch.Donks...)
+	filterchonk(&c)
+	streamto(ch.UserID, "chonk", &c)
+	streamcounts(ch.UserID)
+}
+
+func streamevents(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "can't stream", http.StatusInternalServerError)
+		return
+	}
+	user, err := butwhatabout(u.Username)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s := addstreamer(u.UserID)
+	defer dropstreamer(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	j, _ := jsonify(countsjunk(user))
+	fmt.Fprintf(w, "event: counts\ndata: %s\n\n", strings.TrimSpace(j))
+	flusher.Flush()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-s.events:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.what, ev.data)
+		case <-ticker.C:
+			io.WriteString(w, ": honk\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -60,6 +60,9 @@ func init() {
 			Unveil(viewDir, "r")
 		}
 		Unveil(dataDir, "rwc")
+		if blobDir != "" {
+			Unveil(blobDir, "rwc")
+		}
 		C.unveil(nil, nil)
 		Pledge("stdio rpath wpath cpath flock dns inet unix")
 	})
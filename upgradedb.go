@@ -22,7 +22,7 @@ import (
 	"time"
 )
 
-var myVersion = 41
+var myVersion = 54
 
 type dbexecer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
@@ -206,6 +206,76 @@ func upgradedb() {
 		doordie(db, "update config set value = 41 where key = 'dbversion'")
 		fallthrough
 	case 41:
+		doordie(db, "create table deliveries (deliveryid integer primary key, userid integer, xid text, rcpt text, inbox text, status integer, tries integer, dt text, lasterr text, dooverid integer)")
+		doordie(db, "create index idx_deliveriesxid on deliveries(xid)")
+		doordie(db, "update config set value = 42 where key = 'dbversion'")
+		fallthrough
+	case 42:
+		doordie(db, "create table filecache (xid text, size integer, dt text)")
+		doordie(db, "create index idx_filecachexid on filecache(xid)")
+		doordie(db, "update config set value = 43 where key = 'dbversion'")
+		fallthrough
+	case 43:
+		blobdb := openblobdb()
+		doordie(blobdb, "create table filevariants (xid text, size integer, media text, content blob)")
+		doordie(blobdb, "create index idx_variantxid on filevariants(xid)")
+		doordie(db, "update config set value = 44 where key = 'dbversion'")
+		fallthrough
+	case 44:
+		doordie(db, "alter table filemeta add column meta text")
+		doordie(db, "update filemeta set meta = '{}'")
+		doordie(db, "update config set value = 45 where key = 'dbversion'")
+		fallthrough
+	case 45:
+		if _, err := db.Exec("create virtual table temp.fts5check using fts5(x)"); err != nil {
+			elog.Fatalf("sqlite3 does not support fts5, which search needs: %s", err)
+		}
+		doordie(db, "drop table temp.fts5check")
+		doordie(db, "create virtual table honksearch using fts5(noise, precis, handle, descs, tokenize = 'porter unicode61 remove_diacritics 2')")
+		reindexhonks(db)
+		doordie(db, "update config set value = 46 where key = 'dbversion'")
+		fallthrough
+	case 46:
+		doordie(db, "create table searches (searchid integer primary key, userid integer, json text)")
+		doordie(db, "create index idx_searchesuser on searches(userid)")
+		doordie(db, "update config set value = 47 where key = 'dbversion'")
+		fallthrough
+	case 47:
+		doordie(db, "create table drafts (draftid integer primary key, userid integer, dt text, json text)")
+		doordie(db, "create index idx_draftsuser on drafts(userid)")
+		doordie(db, "update config set value = 48 where key = 'dbversion'")
+		fallthrough
+	case 48:
+		doordie(db, "alter table zonkers add column expiry text")
+		doordie(db, "update zonkers set expiry = ''")
+		doordie(db, "update config set value = 49 where key = 'dbversion'")
+		fallthrough
+	case 49:
+		doordie(db, "create table notifications (notifyid integer primary key, userid integer, what text, who text, xid text, aux text, dt text, unseen integer)")
+		doordie(db, "create index idx_notificationsuser on notifications(userid)")
+		doordie(db, "update config set value = 50 where key = 'dbversion'")
+		fallthrough
+	case 50:
+		doordie(db, "create table oauthapps (appid integer primary key, clientid text, secret text, name text, redirect text, website text, dt text)")
+		doordie(db, "create table oauthgrants (grantid integer primary key, appid integer, userid integer, code text, scope text, dt text, authid integer)")
+		doordie(db, "create index idx_oauthappsclient on oauthapps(clientid)")
+		doordie(db, "create index idx_oauthgrantsuser on oauthgrants(userid)")
+		doordie(db, "update config set value = 51 where key = 'dbversion'")
+		fallthrough
+	case 51:
+		blobdb := openblobdb()
+		doordie(blobdb, "alter table filevariants add column hash text")
+		doordie(db, "update config set value = 52 where key = 'dbversion'")
+		fallthrough
+	case 52:
+		doordie(db, "alter table oauthgrants add column redirect text")
+		doordie(db, "update config set value = 53 where key = 'dbversion'")
+		fallthrough
+	case 53:
+		doordie(db, "alter table deliveries add column msg blob")
+		doordie(db, "update config set value = 54 where key = 'dbversion'")
+		fallthrough
+	case 54:
 
 	default:
 		elog.Fatalf("can't upgrade unknown version %d", dbversion)
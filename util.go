@@ -201,6 +201,16 @@ func initblobdb() {
 		elog.Print(err)
 		return
 	}
+	_, err = blobdb.Exec("create table filevariants (xid text, size integer, media text, hash text, content blob)")
+	if err != nil {
+		elog.Print(err)
+		return
+	}
+	_, err = blobdb.Exec("create index idx_variantxid on filevariants(xid)")
+	if err != nil {
+		elog.Print(err)
+		return
+	}
 	blobdb.Close()
 }
 
@@ -245,12 +255,18 @@ func deluser(username string) {
 	where = " where chonkid in (select chonkid from chonks where userid = ?)"
 	doordie(db, "delete from donks"+where, userid)
 
+	doordie(db, "delete from honksearch where rowid in (select honkid from honks where userid = ?)", userid)
 	doordie(db, "delete from honks where userid = ?", userid)
 	doordie(db, "delete from chonks where userid = ?", userid)
 	doordie(db, "delete from honkers where userid = ?", userid)
 	doordie(db, "delete from zonkers where userid = ?", userid)
 	doordie(db, "delete from doovers where userid = ?", userid)
+	doordie(db, "delete from deliveries where userid = ?", userid)
 	doordie(db, "delete from hfcs where userid = ?", userid)
+	doordie(db, "delete from searches where userid = ?", userid)
+	doordie(db, "delete from drafts where userid = ?", userid)
+	doordie(db, "delete from notifications where userid = ?", userid)
+	doordie(db, "delete from oauthgrants where userid = ?", userid)
 	doordie(db, "delete from auth where userid = ?", userid)
 	doordie(db, "delete from users where userid = ?", userid)
 }
@@ -97,18 +97,7 @@ function fillinhonks(xhr, glowit) {
 	doc.innerHTML = resp.Honks
 	var honks = doc.children
 
-	var mecount = document.getElementById("mecount")
-	if (resp.MeCount) {
-		mecount.innerHTML = "(" + resp.MeCount + ")"
-	} else {
-		mecount.innerHTML = ""
-	}
-	var chatcount = document.getElementById("chatcount")
-	if (resp.ChatCount) {
-		chatcount.innerHTML = "(" + resp.ChatCount + ")"
-	} else {
-		chatcount.innerHTML = ""
-	}
+	updatecounts(resp)
 
 	var srvel = document.getElementById("srvmsg")
 	while (srvel.children[0]) {
@@ -136,8 +125,43 @@ function fillinhonks(xhr, glowit) {
 			
 	}
 	relinklinks()
+	blurimages()
 	return lenhonks
 }
+function updatecounts(resp) {
+	var mecount = document.getElementById("mecount")
+	if (resp.MeCount) {
+		mecount.innerHTML = "(" + resp.MeCount + ")"
+	} else {
+		mecount.innerHTML = ""
+	}
+	var chatcount = document.getElementById("chatcount")
+	if (resp.ChatCount) {
+		chatcount.innerHTML = "(" + resp.ChatCount + ")"
+	} else {
+		chatcount.innerHTML = ""
+	}
+	var notecount = document.getElementById("notecount")
+	if (resp.NoteCount) {
+		notecount.innerHTML = "(" + resp.NoteCount + ")"
+	} else {
+		notecount.innerHTML = ""
+	}
+}
+var streamedhonks = 0
+function listenup() {
+	if (!window.EventSource) {
+		return
+	}
+	var es = new EventSource("/stream")
+	es.addEventListener("counts", function(evt) {
+		updatecounts(JSON.parse(evt.data))
+	})
+	es.addEventListener("honk", function(evt) {
+		streamedhonks++
+		refreshupdate(" " + streamedhonks + " waiting")
+	})
+}
 function hydrargs() {
 	var name = curpagestate.name
 	var arg = curpagestate.arg
@@ -146,6 +170,8 @@ function hydrargs() {
 		args["c"] = arg
 	} else if (name == "combo") {
 		args["c"] = arg
+	} else if (name == "savedsearch") {
+		args["s"] = arg
 	} else if (name == "honker") {
 		args["xid"] = arg
 	} else if (name == "user") {
@@ -161,6 +187,7 @@ function refreshupdate(msg) {
 }
 function refreshhonks(btn) {
 	removeglow()
+	streamedhonks = 0
 	btn.innerHTML = "refreshing"
 	btn.disabled = true
 	var args = hydrargs()
@@ -261,6 +288,11 @@ function relinklinks() {
 		els[0].onclick = pageswitcher("combo", els[0].text)
 		els[0].classList.remove("combolink")
 	}
+	els = document.getElementsByClassName("searchlink")
+	while (els.length) {
+		els[0].onclick = pageswitcher("savedsearch", els[0].text)
+		els[0].classList.remove("searchlink")
+	}
 	els = document.getElementsByClassName("honkerlink")
 	while (els.length) {
 		var el = els[0]
@@ -281,12 +313,90 @@ function relinklinks() {
 	el = document.getElementById("longagolink")
 	el.onclick = pageswitcher("longago", "")
 	relinklinks()
+	listenup()
 	window.onpopstate = statechanger
 	history.replaceState(curpagestate, "some title", "")
 })();
 (function() {
-	hideelement("donkdescriptor")
+	for (var el of document.querySelectorAll(".donkdescriptor"))
+		hideelement(el)
 })();
+var blurchars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+function blur83(s) {
+	var v = 0
+	for (var i = 0; i < s.length; i++)
+		v = v * 83 + blurchars.indexOf(s[i])
+	return v
+}
+function blurlinear(v) {
+	v /= 255
+	return v <= 0.04045 ? v / 12.92 : Math.pow((v + 0.055) / 1.055, 2.4)
+}
+function blursrgb(v) {
+	v = Math.max(0, Math.min(1, v))
+	return Math.round(v <= 0.0031308 ? v * 12.92 * 255 : (1.055 * Math.pow(v, 1 / 2.4) - 0.055) * 255)
+}
+function unblurhash(hash, w, h) {
+	var size = blur83(hash[0])
+	var nx = size % 9 + 1
+	var ny = Math.floor(size / 9) + 1
+	if (hash.length != 4 + 2 * nx * ny)
+		return ""
+	var maxval = (blur83(hash[1]) + 1) / 166
+	var dc = blur83(hash.substring(2, 6))
+	var colors = [[blurlinear(dc >> 16), blurlinear((dc >> 8) & 255), blurlinear(dc & 255)]]
+	var unquant = function(q) {
+		var v = (q - 9) / 9
+		return Math.sign(v) * v * v * maxval
+	}
+	for (var i = 1; i < nx * ny; i++) {
+		var ac = blur83(hash.substring(4 + i * 2, 6 + i * 2))
+		colors.push([unquant(Math.floor(ac / 361)), unquant(Math.floor(ac / 19) % 19), unquant(ac % 19)])
+	}
+	var canvas = document.createElement("canvas")
+	canvas.width = w
+	canvas.height = h
+	var ctx = canvas.getContext("2d")
+	var pix = ctx.createImageData(w, h)
+	for (var y = 0; y < h; y++) {
+		for (var x = 0; x < w; x++) {
+			var r = 0, g = 0, b = 0
+			for (var j = 0; j < ny; j++) {
+				for (var i = 0; i < nx; i++) {
+					var basis = Math.cos(Math.PI * x * i / w) * Math.cos(Math.PI * y * j / h)
+					var c = colors[i + j * nx]
+					r += c[0] * basis
+					g += c[1] * basis
+					b += c[2] * basis
+				}
+			}
+			var off = 4 * (y * w + x)
+			pix.data[off] = blursrgb(r)
+			pix.data[off+1] = blursrgb(g)
+			pix.data[off+2] = blursrgb(b)
+			pix.data[off+3] = 255
+		}
+	}
+	ctx.putImageData(pix, 0, 0)
+	return canvas.toDataURL()
+}
+function blurimages() {
+	for (var el of document.querySelectorAll("img[data-blurhash]")) {
+		var hash = el.getAttribute("data-blurhash")
+		el.removeAttribute("data-blurhash")
+		if (el.complete)
+			continue
+		var url = unblurhash(hash, 32, 32)
+		if (!url)
+			continue
+		el.style.backgroundImage = "url(" + url + ")"
+		el.style.backgroundSize = "100% 100%"
+		el.addEventListener("load", function() {
+			this.style.backgroundImage = ""
+		})
+	}
+}
+document.addEventListener("DOMContentLoaded", blurimages)
 function showhonkform(elem, rid, hname) {
 	var form = lehonkform
 	form.style = "display: block"
@@ -331,13 +441,19 @@ function hideelement(el) {
 	if (!el) return
 	el.style.display = "none"
 }
-function updatedonker() {
-	var el = document.getElementById("donker")
-	el.children[1].textContent = el.children[0].value.slice(-20)
-	var el = document.getElementById("donkdescriptor")
-	el.style.display = ""
-	var el = document.getElementById("saveddonkxid")
+function updatedonker(input) {
+	var donker = input.parentElement.parentElement
+	input.nextElementSibling.textContent = input.value.slice(-20)
+	donker.querySelector(".donkdescriptor").style.display = ""
+	if (donker.nextElementSibling && donker.nextElementSibling.className == "donker")
+		return
+	var more = donker.cloneNode(true)
+	var el = more.querySelector("input[type=file]")
 	el.value = ""
+	el.nextElementSibling.textContent = ""
+	more.querySelector("input[type=text]").value = ""
+	hideelement(more.querySelector(".donkdescriptor"))
+	donker.insertAdjacentElement('afterend', more)
 }
 var checkinprec = 100.0
 var gpsoptions = {
@@ -22,6 +22,7 @@ import (
 	"html/template"
 	"io"
 	notrand "math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -89,6 +90,7 @@ func getInfo(r *http.Request) map[string]interface{} {
 		var combos []string
 		combocache.Get(u.UserID, &combos)
 		templinfo["Combos"] = combos
+		templinfo["Searches"] = getsearchcounts(u.UserID)
 	}
 	return templinfo
 }
@@ -338,7 +340,7 @@ func inbox(w http.ResponseWriter, r *http.Request) {
 	limiter := io.LimitReader(r.Body, 1*1024*1024)
 	io.Copy(&buf, limiter)
 	payload := buf.Bytes()
-	j, err := junk.FromBytes(payload)
+	j, err := parsejunk(payload)
 	if err != nil {
 		ilog.Printf("bad payload: %s", err)
 		ilog.Writer().Write(payload)
@@ -407,6 +409,7 @@ func inbox(w http.ResponseWriter, r *http.Request) {
 			case "Person":
 				return
 			case "Question":
+				pollclosed(user, obj, origin)
 				return
 			case "Note":
 				go xonksaver(user, j, origin)
@@ -435,6 +438,16 @@ func inbox(w http.ResponseWriter, r *http.Request) {
 		default:
 			ilog.Printf("unknown undo: %s", what)
 		}
+	case "Announce":
+		xid := obj
+		if o, ok := j.GetMap("object"); ok {
+			xid, _ = o.GetString("id")
+		}
+		// the same bonk may well arrive more than once
+		if strings.HasPrefix(xid, user.URL+"/") && !notified(user, "bonk", who, xid) {
+			notify(user, "bonk", who, xid, "")
+		}
+		go xonksaver(user, j, origin)
 	case "EmojiReact":
 		obj, ok := j.GetString("object")
 		if ok {
@@ -455,7 +468,7 @@ func serverinbox(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
 	io.Copy(&buf, r.Body)
 	payload := buf.Bytes()
-	j, err := junk.FromBytes(payload)
+	j, err := parsejunk(payload)
 	if err != nil {
 		ilog.Printf("bad payload: %s", err)
 		ilog.Writer().Write(payload)
@@ -733,10 +746,13 @@ func showhonker(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	name := mux.Vars(r)["name"]
 	var honks []*Honk
+	var xid string
 	if name == "" {
 		name = r.FormValue("xid")
+		xid = name
 		honks = gethonksbyxonker(u.UserID, name, 0)
 	} else {
+		stmtOneHonker.QueryRow(name, u.UserID).Scan(&xid)
 		honks = gethonksbyhonker(u.UserID, name, 0)
 	}
 	miniform := templates.Sprintf(`<form action="/submithonker" method="POST">
@@ -746,6 +762,11 @@ func showhonker(w http.ResponseWriter, r *http.Request) {
 </form>`, login.GetCSRF("submithonker", r), name)
 	msg := templates.Sprintf(`honks by honker: <a href="%s" ref="noreferrer">%s</a>%s`, name, name, miniform)
 	templinfo := getInfo(r)
+	if strings.HasPrefix(xid, "https://") && !strings.HasPrefix(xid, serverPrefix) {
+		if p := getprofile(xid); p != nil {
+			templinfo["Profile"] = filterprofile(p)
+		}
+	}
 	templinfo["PageName"] = "honker"
 	templinfo["PageArg"] = name
 	templinfo["ServerMessage"] = msg
@@ -753,6 +774,21 @@ func showhonker(w http.ResponseWriter, r *http.Request) {
 	honkpage(w, u, honks, templinfo)
 }
 
+func filterprofile(orig *Profile) *Profile {
+	p := *orig
+	htmls := []string{p.Summary}
+	for _, f := range orig.Fields {
+		htmls = append(htmls, f.Value)
+	}
+	htmls = filterhtml(p.XID, htmls...)
+	p.HTSummary = template.HTML(htmls[0])
+	p.Fields = append([]ProfileField(nil), orig.Fields...)
+	for i := range p.Fields {
+		p.Fields[i].HTML = template.HTML(htmls[i+1])
+	}
+	return &p
+}
+
 func showcombo(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)["name"]
 	u := login.GetUserInfo(r)
@@ -765,6 +801,18 @@ func showcombo(w http.ResponseWriter, r *http.Request) {
 	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
 	honkpage(w, u, honks, templinfo)
 }
+func convoymessage(r *http.Request, userid int64, c string) template.HTML {
+	if cf := convoyprogress(userid, c); cf != nil {
+		return templates.Sprintf(`honks in convoy: %s<p>fetching missing: %d fetched, %d to go`, c, cf.Fetched, cf.Pending)
+	}
+	miniform := templates.Sprintf(`<form action="/fetchconvoy" method="POST">
+<input type="hidden" name="CSRF" value="%s">
+<input type="hidden" name="c" value="%s">
+<button tabindex=1 name="fetch" value="fetch">fetch missing</button>
+</form>`, login.GetCSRF("fetchconvoy", r), c)
+	return templates.Sprintf(`honks in convoy: %s%s`, c, miniform)
+}
+
 func showconvoy(w http.ResponseWriter, r *http.Request) {
 	c := r.FormValue("c")
 	u := login.GetUserInfo(r)
@@ -777,10 +825,18 @@ func showconvoy(w http.ResponseWriter, r *http.Request) {
 	reversehonks(honks)
 	templinfo["PageName"] = "convoy"
 	templinfo["PageArg"] = c
-	templinfo["ServerMessage"] = "honks in convoy: " + c
+	templinfo["ServerMessage"] = convoymessage(r, u.UserID, c)
 	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
 	honkpage(w, u, honks, templinfo)
 }
+
+func submitfetchconvoy(w http.ResponseWriter, r *http.Request) {
+	c := r.FormValue("c")
+	u := login.GetUserInfo(r)
+	user, _ := butwhatabout(u.Username)
+	go fetchconvoy(user, c)
+	http.Redirect(w, r, "/t?c="+url.QueryEscape(c), http.StatusSeeOther)
+}
 func showsearch(w http.ResponseWriter, r *http.Request) {
 	q := r.FormValue("q")
 	u := login.GetUserInfo(r)
@@ -788,7 +844,30 @@ func showsearch(w http.ResponseWriter, r *http.Request) {
 	templinfo := getInfo(r)
 	templinfo["PageName"] = "search"
 	templinfo["PageArg"] = q
-	templinfo["ServerMessage"] = "honks for search: " + q
+	miniform := templates.Sprintf(`<form action="/savesearch" method="POST">
+<input type="hidden" name="CSRF" value="%s">
+<input type="hidden" name="q" value="%s">
+<input tabindex=1 type="text" name="name" value="" autocomplete=off placeholder="name">
+<button tabindex=1 name="save" value="save">save search</button>
+</form>`, login.GetCSRF("savesearch", r), q)
+	templinfo["ServerMessage"] = templates.Sprintf("honks for search: %s%s", q, miniform)
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	honkpage(w, u, honks, templinfo)
+}
+func showsavedsearch(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	u := login.GetUserInfo(r)
+	ss := getsavedsearch(u.UserID, name)
+	if ss == nil {
+		http.NotFound(w, r)
+		return
+	}
+	honks := gethonksbysavedsearch(u.UserID, ss, 0)
+	seensearch(u.UserID, ss, honks)
+	templinfo := getInfo(r)
+	templinfo["PageName"] = "savedsearch"
+	templinfo["PageArg"] = name
+	templinfo["ServerMessage"] = "honks for saved search: " + name + ": " + ss.Query
 	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
 	honkpage(w, u, honks, templinfo)
 }
@@ -1041,7 +1120,7 @@ func showonehonk(w http.ResponseWriter, r *http.Request) {
 	if u != nil && u.UserID != user.ID {
 		u = nil
 	}
-	if !honk.Public {
+	if !honk.Public || honk.Whofore == 4 {
 		if u == nil {
 			http.NotFound(w, r)
 			return
@@ -1132,6 +1211,16 @@ func saveuser(w http.ResponseWriter, r *http.Request) {
 		options.MapLink = ""
 	}
 	options.Reaction = r.FormValue("reaction")
+	options.Expiration = strings.TrimSpace(r.FormValue("expiration"))
+	if parseDuration(options.Expiration) <= 0 {
+		options.Expiration = ""
+	}
+	options.MutedNotifications = nil
+	for _, what := range notificationTypes {
+		if r.FormValue("notify."+what) != "notify" {
+			options.MutedNotifications = append(options.MutedNotifications, what)
+		}
+	}
 
 	sendupdate := false
 	ava := re_avatar.FindString(whatabout)
@@ -1395,6 +1484,10 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ilog.Printf("zonking %s %s", wherefore, what)
+	var expiry time.Time
+	if dur := parseDuration(r.FormValue("duration")); dur > 0 {
+		expiry = time.Now().Add(dur)
+	}
 	if wherefore == "zonk" {
 		xonk := getxonk(userinfo.UserID, what)
 		if xonk != nil {
@@ -1404,11 +1497,14 @@ func zonkit(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	_, err := stmtSaveZonker.Exec(userinfo.UserID, what, wherefore)
+	err := savezonker(userinfo.UserID, what, wherefore, expiry)
 	if err != nil {
 		elog.Printf("error saving zonker: %s", err)
 		return
 	}
+	if !expiry.IsZero() {
+		pokescheduler()
+	}
 }
 
 func edithonkpage(w http.ResponseWriter, r *http.Request) {
@@ -1439,12 +1535,77 @@ func edithonkpage(w http.ResponseWriter, r *http.Request) {
 			templinfo["Duration"] = tm.Duration
 		}
 	}
+	if honk.Whofore == 4 {
+		templinfo["ShowPublishAt"] = ";"
+		templinfo["PublishAt"] = honk.Date.Local().Format("2006-01-02 15:04")
+	}
 	templinfo["ServerMessage"] = "honk edit 2"
 	templinfo["IsPreview"] = true
 	templinfo["UpdateXID"] = honk.XID
-	if len(honk.Donks) > 0 {
-		templinfo["SavedFile"] = honk.Donks[0].XID
+	var saveddonks []*Donk
+	for _, d := range honk.Donks {
+		if d.Local {
+			saveddonks = append(saveddonks, d)
+		}
 	}
+	templinfo["SavedFiles"] = saveddonks
+	err := readviews.Execute(w, "honkpage.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func showdrafts(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["Drafts"] = getdrafts(u.UserID)
+	templinfo["DraftCSRF"] = login.GetCSRF("draft", r)
+	err := readviews.Execute(w, "drafts.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitwebdraft(w http.ResponseWriter, r *http.Request) {
+	d := submitdraft(w, r)
+	if d == nil {
+		return
+	}
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+func submitdeletedraft(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	deletedraft(u.UserID, draftid)
+	http.Redirect(w, r, "/drafts", http.StatusSeeOther)
+}
+
+func resumedraftpage(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+	d := getdraft(u.UserID, draftid)
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+	templinfo := getInfo(r)
+	templinfo["HonkCSRF"] = login.GetCSRF("honkhonk", r)
+	templinfo["MapLink"] = getmaplink(u)
+	templinfo["Noise"] = d.Noise
+	templinfo["InReplyTo"] = d.RID
+	templinfo["SavedPlace"] = d.Place
+	if tm := d.Time; tm != nil {
+		templinfo["ShowTime"] = ";"
+		templinfo["StartTime"] = tm.StartTime.Local().Format("2006-01-02 15:04")
+		if tm.Duration != 0 {
+			templinfo["Duration"] = tm.Duration
+		}
+	}
+	templinfo["SavedFiles"] = d.Donks()
+	templinfo["DraftID"] = d.ID
+	templinfo["IsPreview"] = true
+	templinfo["ServerMessage"] = "honk draft"
 	err := readviews.Execute(w, "honkpage.html", templinfo)
 	if err != nil {
 		elog.Print(err)
@@ -1476,6 +1637,91 @@ func newhonkpage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func showmutes(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	templinfo := getInfo(r)
+	templinfo["Zonkers"] = getzonkers(u.UserID)
+	templinfo["MuteCSRF"] = login.GetCSRF("unzonk", r)
+	err := readviews.Execute(w, "mutes.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func shownotifications(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	notes := getnotifications(u.UserID, 0)
+	seenotifications(u.UserID)
+	templinfo := getInfo(r)
+	templinfo["Notifications"] = groupnotifications(u.UserID, notes)
+	err := readviews.Execute(w, "notifications.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitunzonk(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	zonkerid, _ := strconv.ParseInt(r.FormValue("zonkerid"), 10, 0)
+	var err error
+	if r.FormValue("action") == "expire" {
+		var expiry time.Time
+		if dur := parseDuration(r.FormValue("duration")); dur > 0 {
+			expiry = time.Now().Add(dur)
+		}
+		err = setzonkerexpiry(u.UserID, zonkerid, expiry)
+		pokescheduler()
+	} else {
+		err = unzonk(u.UserID, zonkerid)
+	}
+	if err != nil {
+		elog.Printf("error unzonking: %s", err)
+	}
+	http.Redirect(w, r, "/mutes", http.StatusSeeOther)
+}
+
+func showhistory(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	xid := r.FormValue("xid")
+	honk := getxonk(u.UserID, xid)
+	if honk == nil {
+		http.NotFound(w, r)
+		return
+	}
+	templinfo := getInfo(r)
+	templinfo["Honk"] = honk
+	templinfo["History"] = honkhistory(honk)
+	err := readviews.Execute(w, "edits.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func showscheduled(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	honks := getscheduledhonks(u.UserID)
+	reverbolate(u.UserID, honks)
+	templinfo := getInfo(r)
+	templinfo["Honks"] = honks
+	templinfo["ScheduleCSRF"] = login.GetCSRF("unschedule", r)
+	err := readviews.Execute(w, "scheduled.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitunschedule(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	xid := r.FormValue("xid")
+	honk := getxonk(u.UserID, xid)
+	if honk == nil || honk.Whofore != 4 {
+		http.Error(w, "no such scheduled honk", http.StatusNotFound)
+		return
+	}
+	deletehonk(honk.ID)
+	http.Redirect(w, r, "/scheduled", http.StatusSeeOther)
+}
+
 func canedithonk(user *WhatAbout, honk *Honk) bool {
 	if honk == nil || honk.Honker != user.URL || honk.What == "bonk" {
 		return false
@@ -1483,25 +1729,132 @@ func canedithonk(user *WhatAbout, honk *Honk) bool {
 	return true
 }
 
-func submitdonk(w http.ResponseWriter, r *http.Request) (*Donk, error) {
+func showdeliveries(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := butwhatabout(u.Username)
+	xid := r.FormValue("xid")
+	honk := getxonk(u.UserID, xid)
+	if !canedithonk(user, honk) {
+		http.Error(w, "no deliveries for that", http.StatusNotFound)
+		return
+	}
+	honks := []*Honk{honk}
+	donksforhonks(honks)
+	reverbolate(u.UserID, honks)
+	templinfo := getInfo(r)
+	templinfo["Honk"] = honk
+	templinfo["Deliveries"] = getdeliveries(u.UserID, xid)
+	templinfo["DeliveryCSRF"] = login.GetCSRF("redeliver", r)
+	err := readviews.Execute(w, "deliveries.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitredeliver(w http.ResponseWriter, r *http.Request) {
+	u := login.GetUserInfo(r)
+	user, _ := butwhatabout(u.Username)
+	deliveryid, _ := strconv.ParseInt(r.FormValue("deliveryid"), 10, 0)
+	d := getdelivery(u.UserID, deliveryid)
+	if d == nil {
+		http.Error(w, "no such delivery", http.StatusNotFound)
+		return
+	}
+	switch r.FormValue("action") {
+	case "retry":
+		err := redeliver(user, d)
+		if err != nil {
+			http.Error(w, "can't retry that: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "cancel":
+		canceldelivery(d)
+	}
+	http.Redirect(w, r, "/deliveries?xid="+url.QueryEscape(d.XID), http.StatusSeeOther)
+}
+
+// upload limits for video and audio, in megabytes
+var maxVideoSize = 50
+var maxAudioSize = 20
+
+// the container has to look like what it claims
+func sniffmedia(data []byte) string {
+	ct := http.DetectContentType(data)
+	switch ct {
+	case "video/mp4":
+		return ct
+	case "video/webm":
+		return ct
+	case "audio/mpeg":
+		return ct
+	case "application/ogg":
+		if len(data) > 36 && bytes.Equal(data[28:36], []byte("OpusHead")) {
+			return "audio/ogg"
+		}
+		if len(data) > 35 && bytes.Equal(data[29:35], []byte("vorbis")) {
+			return "audio/ogg"
+		}
+	case "application/octet-stream":
+		// mp3 without an id3 tag starts right in with a frame sync
+		if len(data) > 1 && data[0] == 0xff && data[1]&0xe0 == 0xe0 {
+			return "audio/mpeg"
+		}
+	}
+	return ct
+}
+
+// each donk file is paired with the donkdesc in the same position
+func submitdonks(w http.ResponseWriter, r *http.Request) ([]*Donk, error) {
 	if !strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/form-data") {
 		return nil, nil
 	}
-	file, filehdr, err := r.FormFile("donk")
+	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
-		if err == http.ErrMissingFile {
-			return nil, nil
-		}
 		elog.Printf("error reading donk: %s", err)
 		http.Error(w, "error reading donk", http.StatusUnsupportedMediaType)
 		return nil, err
 	}
-	var buf bytes.Buffer
-	io.Copy(&buf, file)
-	file.Close()
-	data := buf.Bytes()
+	filehdrs := r.MultipartForm.File["donk"]
+	descs := r.MultipartForm.Value["donkdesc"]
+	var donks []*Donk
+	for i, filehdr := range filehdrs {
+		file, err := filehdr.Open()
+		if err != nil {
+			elog.Printf("error reading donk: %s", err)
+			http.Error(w, "error reading donk", http.StatusUnsupportedMediaType)
+			return nil, err
+		}
+		var buf bytes.Buffer
+		io.Copy(&buf, file)
+		file.Close()
+		var desc string
+		if i < len(descs) {
+			desc = descs[i]
+		}
+		d, err := submitdonk(buf.Bytes(), filehdr, desc)
+		if err != nil {
+			derr := err.(*DonkError)
+			http.Error(w, derr.Msg, derr.StatusCode)
+			return nil, err
+		}
+		donks = append(donks, d)
+	}
+	return donks, nil
+}
+
+// what went wrong with an upload, for whoever's asking to say
+type DonkError struct {
+	Msg        string
+	StatusCode int
+}
+
+func (e *DonkError) Error() string {
+	return e.Msg
+}
+
+func submitdonk(data []byte, filehdr *multipart.FileHeader, desc string) (*Donk, error) {
 	var media, name string
-	img, err := shrinkit(data)
+	img, meta, err := shrinkit(data)
 	if err == nil {
 		data = img.Data
 		format := img.Format
@@ -1511,14 +1864,37 @@ func submitdonk(w http.ResponseWriter, r *http.Request) (*Donk, error) {
 		}
 		name = xfiltrate() + "." + format
 	} else {
-		ct := http.DetectContentType(data)
+		ct := sniffmedia(data)
 		switch ct {
+		case "video/mp4", "video/webm":
+			if len(data) > maxVideoSize*1024*1024 {
+				ilog.Printf("bad image: %s too much video: %d", err, len(data))
+				return nil, &DonkError{"didn't like your attachment", http.StatusUnsupportedMediaType}
+			}
+			media = ct
+			name = filehdr.Filename
+			if name == "" {
+				name = xfiltrate() + "." + ct[6:]
+			}
+		case "audio/mpeg", "audio/ogg":
+			if len(data) > maxAudioSize*1024*1024 {
+				ilog.Printf("bad image: %s too much audio: %d", err, len(data))
+				return nil, &DonkError{"didn't like your attachment", http.StatusUnsupportedMediaType}
+			}
+			media = ct
+			name = filehdr.Filename
+			if name == "" {
+				if ct == "audio/mpeg" {
+					name = xfiltrate() + ".mp3"
+				} else {
+					name = xfiltrate() + ".ogg"
+				}
+			}
 		case "application/pdf":
 			maxsize := 10000000
 			if len(data) > maxsize {
 				ilog.Printf("bad image: %s too much pdf: %d", err, len(data))
-				http.Error(w, "didn't like your attachment", http.StatusUnsupportedMediaType)
-				return nil, err
+				return nil, &DonkError{"didn't like your attachment", http.StatusUnsupportedMediaType}
 			}
 			media = ct
 			name = filehdr.Filename
@@ -1529,14 +1905,12 @@ func submitdonk(w http.ResponseWriter, r *http.Request) (*Donk, error) {
 			maxsize := 100000
 			if len(data) > maxsize {
 				ilog.Printf("bad image: %s too much text: %d", err, len(data))
-				http.Error(w, "didn't like your attachment", http.StatusUnsupportedMediaType)
-				return nil, err
+				return nil, &DonkError{"didn't like your attachment", http.StatusUnsupportedMediaType}
 			}
 			for i := 0; i < len(data); i++ {
 				if data[i] < 32 && data[i] != '\t' && data[i] != '\r' && data[i] != '\n' {
 					ilog.Printf("bad image: %s not text: %d", err, data[i])
-					http.Error(w, "didn't like your attachment", http.StatusUnsupportedMediaType)
-					return nil, err
+					return nil, &DonkError{"didn't like your attachment", http.StatusUnsupportedMediaType}
 				}
 			}
 			media = "text/plain"
@@ -1546,21 +1920,21 @@ func submitdonk(w http.ResponseWriter, r *http.Request) (*Donk, error) {
 			}
 		}
 	}
-	desc := strings.TrimSpace(r.FormValue("donkdesc"))
+	desc = strings.TrimSpace(desc)
 	if desc == "" {
 		desc = name
 	}
-	fileid, xid, err := savefileandxid(name, desc, "", media, true, data)
+	fileid, xid, err := savefileandxid(name, desc, "", media, true, data, meta)
 	if err != nil {
 		elog.Printf("unable to save image: %s", err)
-		http.Error(w, "failed to save attachment", http.StatusUnsupportedMediaType)
-		return nil, err
+		return nil, &DonkError{"failed to save attachment", http.StatusUnsupportedMediaType}
 	}
 	d := &Donk{
 		FileID: fileid,
 		XID:    xid,
 		Desc:   desc,
 		Local:  true,
+		Meta:   meta,
 	}
 	return d, nil
 }
@@ -1592,6 +1966,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	dt := time.Now().UTC()
 	updatexid := r.FormValue("updatexid")
 	var honk *Honk
+	var wasscheduled bool
 	if updatexid != "" {
 		honk = getxonk(userinfo.UserID, updatexid)
 		if !canedithonk(user, honk) {
@@ -1599,7 +1974,11 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 			return nil
 		}
 		honk.Date = dt
-		honk.What = "update"
+		wasscheduled = honk.Whofore == 4
+		// not yet seen by anyone, so not yet an update
+		if !wasscheduled {
+			honk.What = "update"
+		}
 		honk.Format = format
 	} else {
 		xid := fmt.Sprintf("%s/%s/%s", user.URL, honkSep, xfiltrate())
@@ -1675,64 +2054,39 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	honk.Public = loudandproud(honk.Audience)
 	honk.Convoy = convoy
 
-	donkxid := r.FormValue("donkxid")
-	if donkxid == "" {
-		d, err := submitdonk(w, r)
-		if err != nil && err != http.ErrMissingFile {
-			return nil
-		}
-		if d != nil {
-			honk.Donks = append(honk.Donks, d)
-			donkxid = d.XID
+	donks, err := submitdonks(w, r)
+	if err != nil {
+		return nil
+	}
+	for _, xid := range r.Form["donkxid"] {
+		if xid == "" {
+			continue
 		}
-	} else {
-		xid := donkxid
 		url := fmt.Sprintf("https://%s/d/%s", serverName, xid)
 		donk := finddonk(url)
 		if donk != nil {
+			if desc, ok := r.Form["donkdesc."+xid]; ok {
+				if desc := strings.TrimSpace(desc[0]); desc != donk.Desc {
+					donk.Desc = desc
+					donk.redesc = true
+				}
+			}
 			honk.Donks = append(honk.Donks, donk)
 		} else {
 			ilog.Printf("can't find file: %s", xid)
 		}
 	}
+	honk.Donks = append(honk.Donks, donks...)
+	saveddonks := honk.Donks
 	memetize(honk)
 	imaginate(honk)
 
-	placename := strings.TrimSpace(r.FormValue("placename"))
-	placelat := strings.TrimSpace(r.FormValue("placelat"))
-	placelong := strings.TrimSpace(r.FormValue("placelong"))
-	placeurl := strings.TrimSpace(r.FormValue("placeurl"))
-	if placename != "" || placelat != "" || placelong != "" || placeurl != "" {
-		p := new(Place)
-		p.Name = placename
-		p.Latitude, _ = strconv.ParseFloat(placelat, 64)
-		p.Longitude, _ = strconv.ParseFloat(placelong, 64)
-		p.Url = placeurl
+	if p := formplace(r); p != nil {
 		honk.Place = p
 	}
-	timestart := strings.TrimSpace(r.FormValue("timestart"))
-	if timestart != "" {
-		t := new(Time)
-		now := time.Now().Local()
-		for _, layout := range []string{"2006-01-02 3:04pm", "2006-01-02 15:04", "3:04pm", "15:04"} {
-			start, err := time.ParseInLocation(layout, timestart, now.Location())
-			if err == nil {
-				if start.Year() == 0 {
-					start = time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
-				}
-				t.StartTime = start
-				break
-			}
-		}
-		timeend := r.FormValue("timeend")
-		dur := parseDuration(timeend)
-		if dur != 0 {
-			t.Duration = Duration(dur)
-		}
-		if !t.StartTime.IsZero() {
-			honk.What = "event"
-			honk.Time = t
-		}
+	if t := formtime(r); t != nil {
+		honk.What = "event"
+		honk.Time = t
 	}
 
 	if honk.Public {
@@ -1740,6 +2094,36 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 	} else {
 		honk.Whofore = 3
 	}
+	var publishat time.Time
+	if pa := strings.TrimSpace(r.FormValue("publishat")); pa != "" {
+		publishat = parsewhen(pa)
+		if publishat.IsZero() {
+			http.Error(w, "can't understand publish time", http.StatusBadRequest)
+			return nil
+		}
+	}
+	scheduled := publishat.After(time.Now())
+	if scheduled {
+		if updatexid != "" && !wasscheduled {
+			http.Error(w, "already published", http.StatusBadRequest)
+			return nil
+		}
+		honk.Whofore = 4
+		honk.Date = publishat.UTC()
+	} else if wasscheduled {
+		// stays put until publishscheduled sends it out
+		honk.Whofore = 4
+	}
+	if exp := strings.TrimSpace(r.FormValue("expires")); exp != "" {
+		// anything unparseable, like never, turns it off
+		if dur := parseDuration(exp); dur > 0 {
+			honk.Expires = honk.Date.Add(dur)
+		}
+	} else if updatexid != "" {
+		honk.Expires = honkexpiry(honk.ID)
+	} else if dur := parseDuration(user.Options.Expiration); dur > 0 {
+		honk.Expires = honk.Date.Add(dur)
+	}
 
 	// back to markdown
 	honk.Noise = noise
@@ -1753,7 +2137,7 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 		templinfo["MapLink"] = getmaplink(userinfo)
 		templinfo["InReplyTo"] = r.FormValue("rid")
 		templinfo["Noise"] = r.FormValue("noise")
-		templinfo["SavedFile"] = donkxid
+		templinfo["SavedFiles"] = saveddonks
 		if tm := honk.Time; tm != nil {
 			templinfo["ShowTime"] = ";"
 			templinfo["StartTime"] = tm.StartTime.Format("2006-01-02 15:04")
@@ -1761,8 +2145,13 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 				templinfo["Duration"] = tm.Duration
 			}
 		}
+		if scheduled {
+			templinfo["ShowPublishAt"] = ";"
+			templinfo["PublishAt"] = publishat.Format("2006-01-02 15:04")
+		}
 		templinfo["IsPreview"] = true
 		templinfo["UpdateXID"] = updatexid
+		templinfo["DraftID"] = r.FormValue("draftid")
 		templinfo["ServerMessage"] = "honk preview"
 		err := readviews.Execute(w, "honkpage.html", templinfo)
 		if err != nil {
@@ -1781,16 +2170,76 @@ func submithonk(w http.ResponseWriter, r *http.Request) *Honk {
 			return nil
 		}
 	}
+	if draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0); draftid != 0 {
+		deletedraft(userinfo.UserID, draftid)
+	}
 
 	// reload for consistency
 	honk.Donks = nil
 	donksforhonks([]*Honk{honk})
 
-	go honkworldwide(user, honk)
+	if scheduled || !honk.Expires.IsZero() {
+		pokescheduler()
+	}
+	if wasscheduled && !scheduled {
+		// it changes the honk, so give it its own
+		pub := *honk
+		go publishscheduled(&pub)
+	} else if !scheduled {
+		go honkworldwide(user, honk)
+	}
 
 	return honk
 }
 
+func formplace(r *http.Request) *Place {
+	placename := strings.TrimSpace(r.FormValue("placename"))
+	placelat := strings.TrimSpace(r.FormValue("placelat"))
+	placelong := strings.TrimSpace(r.FormValue("placelong"))
+	placeurl := strings.TrimSpace(r.FormValue("placeurl"))
+	if placename == "" && placelat == "" && placelong == "" && placeurl == "" {
+		return nil
+	}
+	p := new(Place)
+	p.Name = placename
+	p.Latitude, _ = strconv.ParseFloat(placelat, 64)
+	p.Longitude, _ = strconv.ParseFloat(placelong, 64)
+	p.Url = placeurl
+	return p
+}
+
+func formtime(r *http.Request) *Time {
+	timestart := strings.TrimSpace(r.FormValue("timestart"))
+	if timestart == "" {
+		return nil
+	}
+	t := new(Time)
+	t.StartTime = parsewhen(timestart)
+	if t.StartTime.IsZero() {
+		return nil
+	}
+	dur := parseDuration(r.FormValue("timeend"))
+	if dur != 0 {
+		t.Duration = Duration(dur)
+	}
+	return t
+}
+
+// a date and time, or just a time today
+func parsewhen(s string) time.Time {
+	now := time.Now().Local()
+	for _, layout := range []string{"2006-01-02 3:04pm", "2006-01-02 15:04", "3:04pm", "15:04"} {
+		when, err := time.ParseInLocation(layout, s, now.Location())
+		if err == nil {
+			if when.Year() == 0 {
+				when = time.Date(now.Year(), now.Month(), now.Day(), when.Hour(), when.Minute(), 0, 0, now.Location())
+			}
+			return when
+		}
+	}
+	return time.Time{}
+}
+
 func showhonkers(w http.ResponseWriter, r *http.Request) {
 	userinfo := login.GetUserInfo(r)
 	templinfo := getInfo(r)
@@ -1846,13 +2295,11 @@ func submitchonk(w http.ResponseWriter, r *http.Request) {
 		Noise:  noise,
 		Format: format,
 	}
-	d, err := submitdonk(w, r)
-	if err != nil && err != http.ErrMissingFile {
+	donks, err := submitdonks(w, r)
+	if err != nil {
 		return
 	}
-	if d != nil {
-		ch.Donks = append(ch.Donks, d)
-	}
+	ch.Donks = append(ch.Donks, donks...)
 
 	translatechonk(&ch)
 	savechonk(&ch)
@@ -2019,6 +2466,64 @@ func savehfcs(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/hfcs", http.StatusSeeOther)
 }
 
+func searchespage(w http.ResponseWriter, r *http.Request) {
+	templinfo := getInfo(r)
+	templinfo["SearchCSRF"] = login.GetCSRF("savesearch", r)
+	err := readviews.Execute(w, "searches.html", templinfo)
+	if err != nil {
+		elog.Print(err)
+	}
+}
+
+func submitsavesearch(w http.ResponseWriter, r *http.Request) {
+	userinfo := login.GetUserInfo(r)
+	itsok := r.FormValue("itsok")
+	if itsok == "iforgiveyou" {
+		searchid, _ := strconv.ParseInt(r.FormValue("searchid"), 10, 0)
+		_, err := stmtDeleteSearch.Exec(userinfo.UserID, searchid)
+		if err != nil {
+			elog.Printf("error deleting search: %s", err)
+		}
+		searchInvalidator.Clear(userinfo.UserID)
+		http.Redirect(w, r, "/searches", http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	q := strings.TrimSpace(r.FormValue("q"))
+	re_namecheck := regexp.MustCompile("^[\\pL[:digit:]_.-]+$")
+	if !re_namecheck.MatchString(name) {
+		http.Error(w, "please use a plainer name", http.StatusInternalServerError)
+		return
+	}
+	if q == "" {
+		http.Error(w, "can't save a blank search", http.StatusInternalServerError)
+		return
+	}
+	ss := getsavedsearch(userinfo.UserID, name)
+	if ss != nil {
+		c := *ss
+		ss = &c
+	} else {
+		ss = new(SavedSearch)
+		ss.Name = name
+		ss.Date = time.Now().UTC()
+	}
+	if ss.Query != q {
+		ss.Query = q
+		// start from now, not from the beginning of time
+		ss.Seen = 0
+		if honks := gethonksbysavedsearch(userinfo.UserID, ss, 0); len(honks) > 0 {
+			ss.Seen = honks[0].ID
+		}
+	}
+	err := savesearch(userinfo.UserID, ss)
+	if err != nil {
+		elog.Printf("error saving search: %s", err)
+	}
+	http.Redirect(w, r, "/s/"+name, http.StatusSeeOther)
+}
+
 func accountpage(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	user, _ := butwhatabout(u.Username)
@@ -2034,6 +2539,13 @@ func accountpage(w http.ResponseWriter, r *http.Request) {
 		about += "\n\nbanner: " + ban[strings.LastIndexByte(ban, '/')+1:]
 	}
 	templinfo["WhatAbout"] = about
+	notifyon := make(map[string]bool)
+	for _, what := range notificationTypes {
+		notifyon[what] = !notificationmuted(user, what)
+	}
+	templinfo["NotifyOn"] = notifyon
+	templinfo["Grants"] = getoauthgrants(user.ID)
+	templinfo["OAuthCSRF"] = login.GetCSRF("oauth", r)
 	err := readviews.Execute(w, "account.html", templinfo)
 	if err != nil {
 		elog.Print(err)
@@ -2108,6 +2620,22 @@ func avatate(w http.ResponseWriter, r *http.Request) {
 		loadAvatarColors()
 	}
 	n := r.FormValue("a")
+	if strings.HasPrefix(n, "https://") && !strings.HasPrefix(n, serverPrefix) {
+		if p := cachedprofile(n); p != nil {
+			if p.Avatar != "" {
+				media, data, err := loadblob(p.Avatar)
+				if err == nil {
+					w.Header().Set("Content-Type", media)
+					w.Header().Set("X-Content-Type-Options", "nosniff")
+					w.Header().Set("Cache-Control", "max-age="+somedays())
+					w.Write(data)
+					return
+				}
+			}
+		} else if login.GetUserInfo(r) != nil {
+			refetchprofile(n)
+		}
+	}
 	hex := r.FormValue("hex") == "1"
 	a := genAvatar(n, hex)
 	if !develMode {
@@ -2171,19 +2699,35 @@ func servememe(w http.ResponseWriter, r *http.Request) {
 
 func servefile(w http.ResponseWriter, r *http.Request) {
 	xid := mux.Vars(r)["xid"]
-	var media string
-	var data []byte
-	row := stmtGetFileData.QueryRow(xid)
-	err := row.Scan(&media, &data)
-	if err != nil {
+	media, data, err := loadblob(xid)
+	if err == sql.ErrNoRows {
+		var ok bool
+		media, data, ok = refetchfile(xid)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+	} else if err != nil {
 		elog.Printf("error loading file: %s", err)
 		http.NotFound(w, r)
 		return
 	}
+	touchfile(xid)
+	if s := r.FormValue("s"); s != "" && (media == "image/jpeg" || media == "image/png") {
+		size, _ := strconv.Atoi(s)
+		for _, v := range imageVariants {
+			if v != size {
+				continue
+			}
+			if vmedia, vdata, ok := getvariant(xid, size); ok {
+				media, data = vmedia, vdata
+			}
+		}
+	}
 	w.Header().Set("Content-Type", media)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("Cache-Control", "max-age="+somedays())
-	w.Write(data)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
 }
 
 func nomoroboto(w http.ResponseWriter, r *http.Request) {
@@ -2205,6 +2749,7 @@ type Hydration struct {
 	Honks     string
 	MeCount   int64
 	ChatCount int64
+	NoteCount int64
 }
 
 func webhydra(w http.ResponseWriter, r *http.Request) {
@@ -2246,11 +2791,18 @@ func webhydra(w http.ResponseWriter, r *http.Request) {
 		honks = gethonksbycombo(userid, c, wanted)
 		honks = osmosis(honks, userid, false)
 		hydra.Srvmsg = templates.Sprintf("honks by combo: %s", c)
+	case "savedsearch":
+		name := r.FormValue("s")
+		if ss := getsavedsearch(userid, name); ss != nil {
+			honks = gethonksbysavedsearch(userid, ss, wanted)
+			seensearch(userid, ss, honks)
+			hydra.Srvmsg = templates.Sprintf("honks for saved search: %s: %s", name, ss.Query)
+		}
 	case "convoy":
 		c := r.FormValue("c")
 		honks = gethonksbyconvoy(userid, c, wanted)
 		honks = osmosis(honks, userid, false)
-		hydra.Srvmsg = templates.Sprintf("honks in convoy: %s", c)
+		hydra.Srvmsg = convoymessage(r, userid, c)
 	case "honker":
 		xid := r.FormValue("xid")
 		honks = gethonksbyxonker(userid, xid, wanted)
@@ -2290,6 +2842,7 @@ func webhydra(w http.ResponseWriter, r *http.Request) {
 	hydra.Honks = buf.String()
 	hydra.MeCount = user.Options.MeCount
 	hydra.ChatCount = user.Options.ChatCount
+	hydra.NoteCount = user.Options.NoteCount
 	w.Header().Set("Content-Type", "application/json")
 	j, _ := jsonify(&hydra)
 	io.WriteString(w, j)
@@ -2307,12 +2860,29 @@ func honkhonkline() {
 	}
 }
 
+// all a read token is good for
+var apireadactions = map[string]bool{
+	"drafts":        true,
+	"mutes":         true,
+	"stream":        true,
+	"notifications": true,
+	"search":        true,
+	"gethonks":      true,
+	"searches":      true,
+}
+
 func apihandler(w http.ResponseWriter, r *http.Request) {
 	u := login.GetUserInfo(r)
 	userid := u.UserID
 	action := r.FormValue("action")
 	wait, _ := strconv.ParseInt(r.FormValue("wait"), 10, 0)
 	dlog.Printf("api request '%s' on behalf of %s", action, u.Username)
+	// marking notifications seen counts as writing
+	readonly := apireadactions[action] && r.FormValue("seen") != "1"
+	if !readonly && !tokencanwrite(r) {
+		http.Error(w, "token can only read", http.StatusForbidden)
+		return
+	}
 	switch action {
 	case "honk":
 		h := submithonk(w, r)
@@ -2321,18 +2891,91 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Write([]byte(h.XID))
 	case "donk":
-		d, err := submitdonk(w, r)
+		donks, err := submitdonks(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if d == nil {
+		if len(donks) == 0 {
 			http.Error(w, "missing donk", http.StatusBadRequest)
 			return
 		}
-		w.Write([]byte(d.XID))
+		for i, d := range donks {
+			if i > 0 {
+				w.Write([]byte("\n"))
+			}
+			w.Write([]byte(d.XID))
+		}
 	case "zonkit":
 		zonkit(w, r)
+	case "savedraft":
+		d := submitdraft(w, r)
+		if d == nil {
+			return
+		}
+		fmt.Fprintf(w, "%d", d.ID)
+	case "drafts":
+		var drafts []junk.Junk
+		for _, d := range getdrafts(userid) {
+			dj := junk.New()
+			dj["draftid"] = d.ID
+			dj["draft"] = d
+			drafts = append(drafts, dj)
+		}
+		j := junk.New()
+		j["drafts"] = drafts
+		j.Write(w)
+	case "mutes":
+		var mutes []junk.Junk
+		for wherefore, zonkers := range getzonkers(userid) {
+			for _, z := range zonkers {
+				m := junk.New()
+				m["zonkerid"] = z.ID
+				m["wherefore"] = wherefore
+				m["what"] = z.Name
+				if !z.Expiry.IsZero() {
+					m["expiry"] = z.Expiry.Format(time.RFC3339)
+				}
+				if z.Honk != nil {
+					m["honk"] = z.Honk
+				}
+				mutes = append(mutes, m)
+			}
+		}
+		j := junk.New()
+		j["mutes"] = mutes
+		j.Write(w)
+	case "stream":
+		streamevents(w, r)
+	case "notifications":
+		after, _ := strconv.ParseInt(r.FormValue("after"), 10, 0)
+		notes := getnotifications(userid, after)
+		if r.FormValue("seen") == "1" {
+			seenotifications(userid)
+		}
+		j := junk.New()
+		j["notifications"] = notes
+		j.Write(w)
+	case "unmute":
+		zonkerid, _ := strconv.ParseInt(r.FormValue("zonkerid"), 10, 0)
+		err := unzonk(userid, zonkerid)
+		if err != nil {
+			elog.Printf("error unzonking: %s", err)
+		}
+	case "deletedraft":
+		draftid, _ := strconv.ParseInt(r.FormValue("draftid"), 10, 0)
+		deletedraft(userid, draftid)
+	case "search":
+		q := r.FormValue("q")
+		if q == "" {
+			http.Error(w, "need a query", http.StatusBadRequest)
+			return
+		}
+		wanted, _ := strconv.ParseInt(r.FormValue("after"), 10, 0)
+		honks := gethonksbysearch(userid, q, wanted)
+		reverbolate(userid, honks)
+		j := junk.New()
+		j["honks"] = honks
+		j.Write(w)
 	case "gethonks":
 		var honks []*Honk
 		wanted, _ := strconv.ParseInt(r.FormValue("after"), 10, 0)
@@ -2353,6 +2996,16 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		case "myhonks":
 			honks = gethonksbyuser(u.Username, true, wanted)
 			honks = osmosis(honks, userid, true)
+		case "scheduled":
+			honks = getscheduledhonks(userid)
+		case "savedsearch":
+			ss := getsavedsearch(userid, r.FormValue("name"))
+			if ss == nil {
+				http.Error(w, "unknown search", http.StatusNotFound)
+				return
+			}
+			honks = gethonksbysavedsearch(userid, ss, wanted)
+			seensearch(userid, ss, honks)
 		default:
 			http.Error(w, "unknown page", http.StatusNotFound)
 			return
@@ -2371,6 +3024,18 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		j := junk.New()
 		j["honks"] = honks
 		j.Write(w)
+	case "searches":
+		j := junk.New()
+		var searches []junk.Junk
+		for _, ss := range getsearchcounts(userid) {
+			s := junk.New()
+			s["name"] = ss.Name
+			s["q"] = ss.Query
+			s["unread"] = ss.Unread
+			searches = append(searches, s)
+		}
+		j["searches"] = searches
+		j.Write(w)
 	case "sendactivity":
 		user, _ := butwhatabout(u.Username)
 		public := r.FormValue("public") == "1"
@@ -2379,6 +3044,20 @@ func apihandler(w http.ResponseWriter, r *http.Request) {
 		for rcpt := range rcpts {
 			go deliverate(0, userid, rcpt, msg, true)
 		}
+	case "preview":
+		link := r.FormValue("url")
+		if !strings.HasPrefix(link, "https://") {
+			http.Error(w, "need a url", http.StatusBadRequest)
+			return
+		}
+		p := linkpreview(link)
+		if p == nil {
+			http.Error(w, "no preview", http.StatusNotFound)
+			return
+		}
+		j := junk.New()
+		j["preview"] = p
+		j.Write(w)
 	default:
 		http.Error(w, "unknown action", http.StatusNotFound)
 		return
@@ -2430,8 +3109,10 @@ func serve() {
 	runBackendServer()
 	go enditall()
 	go redeliverator()
+	go scheduler()
 	go tracker()
 	go bgmonitor()
+	go mediacachetrimmer()
 	loadLingo()
 
 	readviews = templates.Load(develMode,
@@ -2440,6 +3121,13 @@ func serve() {
 		viewDir+"/views/honkers.html",
 		viewDir+"/views/chatter.html",
 		viewDir+"/views/hfcs.html",
+		viewDir+"/views/searches.html",
+		viewDir+"/views/scheduled.html",
+		viewDir+"/views/drafts.html",
+		viewDir+"/views/edits.html",
+		viewDir+"/views/mutes.html",
+		viewDir+"/views/notifications.html",
+		viewDir+"/views/authorize.html",
 		viewDir+"/views/combos.html",
 		viewDir+"/views/honkform.html",
 		viewDir+"/views/honk.html",
@@ -2451,6 +3139,7 @@ func serve() {
 		viewDir+"/views/msg.html",
 		viewDir+"/views/header.html",
 		viewDir+"/views/onts.html",
+		viewDir+"/views/deliveries.html",
 		viewDir+"/views/honkpage.js",
 	)
 	if !develMode {
@@ -2474,6 +3163,17 @@ func serve() {
 	mux.Use(login.Checker)
 
 	mux.Handle("/api", login.TokenRequired(http.HandlerFunc(apihandler)))
+	mux.Handle("/api/v1/accounts/verify_credentials", login.TokenRequired(http.HandlerFunc(mastoverify))).Methods("GET")
+	mux.Handle("/api/v1/timelines/home", login.TokenRequired(http.HandlerFunc(mastohome))).Methods("GET")
+	mux.Handle("/api/v1/notifications", login.TokenRequired(http.HandlerFunc(mastonotifications))).Methods("GET")
+	mux.Handle("/api/v1/statuses", login.TokenRequired(writerequired(http.HandlerFunc(mastopost)))).Methods("POST")
+	mux.Handle("/api/v1/statuses/{id:[0-9]+}", login.TokenRequired(writerequired(http.HandlerFunc(mastoonestatus)))).Methods("GET", "DELETE")
+	mux.Handle("/api/v1/statuses/{id:[0-9]+}/{action:favourite|reblog}", login.TokenRequired(writerequired(http.HandlerFunc(mastoaction)))).Methods("POST")
+	mux.Handle("/api/v2/media", login.TokenRequired(writerequired(http.HandlerFunc(mastoupload)))).Methods("POST")
+	mux.HandleFunc("/api/v1/instance", mastoinstance).Methods("GET")
+	mux.HandleFunc("/api/v1/apps", oauthapps).Methods("POST")
+	mux.HandleFunc("/oauth/token", oauthtoken).Methods("POST")
+	mux.HandleFunc("/oauth/revoke", oauthrevoke).Methods("POST")
 
 	posters := mux.Methods("POST").Subrouter()
 	getters := mux.Methods("GET").Subrouter()
@@ -2536,6 +3236,22 @@ func serve() {
 	loggedin.HandleFunc("/xzone", xzone)
 	loggedin.HandleFunc("/newhonk", newhonkpage)
 	loggedin.HandleFunc("/edit", edithonkpage)
+	loggedin.HandleFunc("/deliveries", showdeliveries)
+	loggedin.HandleFunc("/scheduled", showscheduled)
+	loggedin.HandleFunc("/drafts", showdrafts)
+	loggedin.HandleFunc("/edits", showhistory)
+	loggedin.HandleFunc("/mutes", showmutes)
+	loggedin.HandleFunc("/notifications", shownotifications)
+	loggedin.HandleFunc("/stream", streamevents)
+	loggedin.HandleFunc("/oauth/authorize", showauthorize).Methods("GET")
+	loggedin.Handle("/oauth/authorize", login.CSRFWrap("oauth", http.HandlerFunc(submitauthorize))).Methods("POST")
+	loggedin.Handle("/revokeapp", login.CSRFWrap("oauth", http.HandlerFunc(submitrevokeapp)))
+	loggedin.Handle("/unzonk", login.CSRFWrap("unzonk", http.HandlerFunc(submitunzonk)))
+	loggedin.HandleFunc("/draft", resumedraftpage)
+	loggedin.Handle("/savedraft", login.CSRFWrap("honkhonk", http.HandlerFunc(submitwebdraft)))
+	loggedin.Handle("/deletedraft", login.CSRFWrap("draft", http.HandlerFunc(submitdeletedraft)))
+	loggedin.Handle("/unschedule", login.CSRFWrap("unschedule", http.HandlerFunc(submitunschedule)))
+	loggedin.Handle("/redeliver", login.CSRFWrap("redeliver", http.HandlerFunc(submitredeliver)))
 	loggedin.Handle("/honk", login.CSRFWrap("honkhonk", http.HandlerFunc(submitwebhonk)))
 	loggedin.Handle("/bonk", login.CSRFWrap("honkhonk", http.HandlerFunc(submitbonk)))
 	loggedin.Handle("/zonkit", login.CSRFWrap("honkhonk", http.HandlerFunc(zonkit)))
@@ -2548,7 +3264,11 @@ func serve() {
 	loggedin.HandleFunc("/c/{name:[\\pL[:digit:]_.-]+}", showcombo)
 	loggedin.HandleFunc("/c", showcombos)
 	loggedin.HandleFunc("/t", showconvoy)
+	loggedin.Handle("/fetchconvoy", login.CSRFWrap("fetchconvoy", http.HandlerFunc(submitfetchconvoy)))
 	loggedin.HandleFunc("/q", showsearch)
+	loggedin.HandleFunc("/s/{name:[\\pL[:digit:]_.-]+}", showsavedsearch)
+	loggedin.HandleFunc("/searches", searchespage)
+	loggedin.Handle("/savesearch", login.CSRFWrap("savesearch", http.HandlerFunc(submitsavesearch)))
 	loggedin.HandleFunc("/hydra", webhydra)
 	loggedin.Handle("/submithonker", login.CSRFWrap("submithonker", http.HandlerFunc(submithonker)))
 